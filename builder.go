@@ -0,0 +1,487 @@
+package eslocationseed
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Turns CSV records into Elasticsearch documents. Embedders can wrap the
+// default builder to compute some fields differently or add derived fields.
+type DocumentBuilder interface {
+	BuildDocument(record []string, columns Columns) (map[string]interface{}, error)
+}
+
+// Positions of the document fields in the records of the file being imported
+type Columns struct {
+	// Mapped fields by logical name
+	Fields map[string]int
+
+	// Fields indexed as booleans and as dates
+	Bools map[string]int
+	Dates map[string]int
+}
+
+// Builds documents the way the command line importer does, as configured
+type DefaultDocumentBuilder struct {
+	cfg Config
+
+	// Parses the latlng column, the WKT point parser when nil
+	ParseLatLng func(value string) (lat, lon float64, err error)
+}
+
+// Creates the default document builder for a configuration
+func NewDocumentBuilder(cfg Config) *DefaultDocumentBuilder {
+	return &DefaultDocumentBuilder{cfg: cfg}
+}
+
+// Builds the Elasticsearch document for a CSV record
+func (b *DefaultDocumentBuilder) BuildDocument(record []string, columns Columns) (map[string]interface{}, error) {
+	for field, i := range columns.Fields {
+		if i >= len(record) {
+			return nil, fmt.Errorf("missing %s field, record has %d columns", field, len(record))
+		}
+	}
+	for field, i := range columns.Bools {
+		if i >= len(record) {
+			return nil, fmt.Errorf("missing %s field, record has %d columns", field, len(record))
+		}
+	}
+	for field, i := range columns.Dates {
+		if i >= len(record) {
+			return nil, fmt.Errorf("missing %s field, record has %d columns", field, len(record))
+		}
+	}
+
+	raw := record
+	record, nulls := b.nullFields(b.transform(record, columns), columns)
+
+	document := map[string]interface{}{
+		"placeId":               record[columns.Fields["placeId"]],
+		"address":               record[columns.Fields["address"]],
+		"types":                 splitValues(record[columns.Fields["types"]], b.cfg.TypesSeparator),
+		"isAutocompleteAddress": record[columns.Fields["isAutocompleteAddress"]],
+		"country":               record[columns.Fields["country"]],
+		"city":                  record[columns.Fields["city"]],
+		"division":              record[columns.Fields["division"]],
+		"district":              record[columns.Fields["district"]],
+		"postalCode":            record[columns.Fields["postalCode"]],
+		"plusCode":              record[columns.Fields["plusCode"]],
+	}
+
+	// Parse latlng field, documents without a geometry are indexed without it
+	if geometry := strings.TrimSpace(record[columns.Fields["latlng"]]); geometry != "" && !emptyPointRegex.MatchString(geometry) && b.keepField("latlng") {
+		location, err := b.location(geometry)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing latlng field: %w", err)
+		}
+		document["latlng"] = location
+	}
+
+	// Coerce the boolean columns, replacing their string values
+	for field, i := range columns.Bools {
+		if !b.keepField(field) || nulls[field] {
+			continue
+		}
+		v, err := parseBool(record[i])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s field: %w", field, err)
+		}
+		document[field] = v
+	}
+
+	// Reject malformed plus codes, the raw value is indexed
+	if b.cfg.ValidatePlusCode {
+		if err := checkPlusCode(record[columns.Fields["plusCode"]]); err != nil {
+			return nil, fmt.Errorf("error parsing plusCode field: %w", err)
+		}
+	}
+
+	// Parse the date columns, replacing their string values
+	for field, i := range columns.Dates {
+		if !b.keepField(field) || nulls[field] {
+			continue
+		}
+		date, err := parseDate(record[i], b.cfg.DateLayout)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s field: %w", field, err)
+		}
+		document[field] = date
+	}
+
+	// Convert the fields with a configured type
+	for field, typ := range b.cfg.FieldTypes {
+		if !b.keepField(field) || nulls[field] {
+			continue
+		}
+		value, err := convertValue(record[columns.Fields[field]], typ)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s field: %w", field, err)
+		}
+		document[field] = value
+	}
+
+	// Map the looked up values, the values of other fields are added after the renames
+	looked, err := b.lookup(document, record, columns, nulls)
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop the absent fields and those left out of the projection, their
+	// values are not parsed
+	for field := range document {
+		if !b.keepField(field) || nulls[field] {
+			delete(document, field)
+		}
+	}
+
+	// Rename the fields for the target index
+	for field, name := range b.cfg.FieldNames {
+		if value, ok := document[field]; ok {
+			delete(document, field)
+			document[name] = value
+		}
+	}
+
+	for target, value := range looked {
+		document[target] = value
+	}
+	for field, value := range b.cfg.ConstantFields {
+		document[field] = value
+	}
+
+	if b.cfg.IngestTimestamp {
+		document["ingestedAt"] = time.Now().UTC().Format(time.RFC3339)
+	}
+	if b.cfg.KeepRaw != "" {
+		document[b.cfg.KeepRaw] = b.rawRow(raw)
+	}
+	return document, nil
+}
+
+// Joins the columns of a record back into a CSV row, quoting them as needed
+func (b *DefaultDocumentBuilder) rawRow(record []string) string {
+	var buf strings.Builder
+	w := csv.NewWriter(&buf)
+	w.Comma = b.cfg.Delimiter
+	w.Write(record)
+	w.Flush()
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// String transforms selectable with -transform
+var stringTransforms = map[string]func(string) string{
+	"trim":            strings.TrimSpace,
+	"lower":           strings.ToLower,
+	"upper":           strings.ToUpper,
+	"title":           titleCase,
+	"collapse-spaces": func(s string) string { return strings.Join(strings.Fields(s), " ") },
+}
+
+// Returns a copy of the record with the configured transforms applied to the
+// values of their fields
+func (b *DefaultDocumentBuilder) transform(record []string, columns Columns) []string {
+	if len(b.cfg.Transforms) == 0 {
+		return record
+	}
+	record = slices.Clone(record)
+	for field, names := range b.cfg.Transforms {
+		i, ok := columns.Fields[field]
+		if !ok {
+			i, ok = columns.Bools[field]
+		}
+		if !ok {
+			i, ok = columns.Dates[field]
+		}
+		if !ok || i >= len(record) {
+			continue
+		}
+		for _, name := range names {
+			record[i] = stringTransforms[name](record[i])
+		}
+	}
+	return record
+}
+
+// Replaces the values of the fields looked up in place, returning the values
+// of the target fields
+func (b *DefaultDocumentBuilder) lookup(document map[string]interface{}, record []string, columns Columns, nulls map[string]bool) (map[string]string, error) {
+	var looked map[string]string
+	for _, lookup := range b.cfg.Lookups {
+		value := strings.TrimSpace(record[columns.Fields[lookup.field]])
+		if nulls[lookup.field] || value == "" {
+			continue
+		}
+		mapped, found := lookup.values[value]
+		if !found {
+			switch b.cfg.LookupUnmapped {
+			case "fail":
+				return nil, fmt.Errorf("error looking up %s field: no mapping for %q", lookup.field, value)
+			case "drop":
+				if lookup.target == lookup.field {
+					delete(document, lookup.field)
+				}
+				continue
+			}
+			mapped = value
+		}
+		if lookup.target == lookup.field {
+			document[lookup.field] = mapped
+			continue
+		}
+		if looked == nil {
+			looked = make(map[string]string)
+		}
+		looked[lookup.target] = mapped
+	}
+	return looked, nil
+}
+
+// Finds the fields holding one of the -null-values, returning the record with
+// their values emptied so they parse as absent
+func (b *DefaultDocumentBuilder) nullFields(record []string, columns Columns) ([]string, map[string]bool) {
+	if len(b.cfg.NullValues) == 0 {
+		return record, nil
+	}
+	nulls := make(map[string]bool)
+	for _, cols := range []map[string]int{columns.Fields, columns.Bools, columns.Dates} {
+		for field, i := range cols {
+			if i >= len(record) || !slices.Contains(b.cfg.NullValues, strings.TrimSpace(record[i])) {
+				continue
+			}
+			if len(nulls) == 0 {
+				record = slices.Clone(record)
+			}
+			nulls[field] = true
+			record[i] = ""
+		}
+	}
+	return record, nulls
+}
+
+// Capitalizes the first letter of every word and lowercases the others
+func titleCase(s string) string {
+	runes := []rune(strings.ToLower(s))
+	start := true
+	for i, r := range runes {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start {
+				runes[i] = unicode.ToUpper(r)
+			}
+			start = false
+		} else {
+			start = r != '\''
+		}
+	}
+	return string(runes)
+}
+
+// Returns the latlng value for the geo type: a geo_point object, a GeoJSON
+// point or the WKT as is for geo_shape fields
+func (b *DefaultDocumentBuilder) location(geometry string) (interface{}, error) {
+	if b.cfg.GeoType == "wkt" {
+		return geometry, nil
+	}
+	parse := b.parseLatLng
+	if b.ParseLatLng != nil {
+		parse = b.ParseLatLng
+	}
+	lat, lon, err := parse(geometry)
+	if err != nil {
+		return nil, err
+	}
+	if b.cfg.GeoType == "shape" {
+		return map[string]interface{}{"type": "Point", "coordinates": []float64{lon, lat}}, nil
+	}
+	return map[string]interface{}{"lat": lat, "lon": lon}, nil
+}
+
+// Returns whether a field is kept by -fields-include and -fields-exclude
+func (b *DefaultDocumentBuilder) keepField(field string) bool {
+	if len(b.cfg.FieldsInclude) > 0 {
+		return slices.Contains(b.cfg.FieldsInclude, field)
+	}
+	return !slices.Contains(b.cfg.FieldsExclude, field)
+}
+
+// Checks a full or short Open Location Code, empty codes are accepted
+func checkPlusCode(code string) error {
+	const alphabet = "23456789CFGHJMPQRVWX"
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return nil
+	}
+
+	sep := strings.IndexByte(code, '+')
+	if sep < 2 || sep != strings.LastIndexByte(code, '+') || sep > 8 || sep%2 != 0 {
+		return fmt.Errorf("invalid plus code %q: misplaced + separator", code)
+	}
+	// Full codes may stop at the separator, short codes need the pair after
+	// it, and no code has more than 15 digits
+	switch after := len(code) - sep - 1; {
+	case after == 1:
+		return fmt.Errorf("invalid plus code %q: single character after the separator", code)
+	case after == 0 && sep < 8:
+		return fmt.Errorf("invalid plus code %q: short code without characters after the separator", code)
+	case after > 7:
+		return fmt.Errorf("invalid plus code %q: more than 7 characters after the separator", code)
+	}
+
+	// Padding completes codes shorter than 8 digits, it is only allowed in
+	// pairs before the separator of a full code
+	if pad := strings.IndexByte(code, '0'); pad >= 0 {
+		end := strings.LastIndexByte(code, '0') + 1
+		if pad == 0 || sep != 8 || end != sep || (end-pad)%2 != 0 || strings.Trim(code[pad:end], "0") != "" || len(code) > sep+1 {
+			return fmt.Errorf("invalid plus code %q: misplaced 0 padding", code)
+		}
+	}
+
+	for _, c := range code {
+		if c != '+' && c != '0' && !strings.ContainsRune(alphabet, c) {
+			return fmt.Errorf("invalid plus code %q: unexpected character %q", code, c)
+		}
+	}
+	return nil
+}
+
+// Parses a date in the given layout as an RFC3339 UTC timestamp, empty
+// dates become null
+func parseDate(value, layout string) (interface{}, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	date, err := time.Parse(layout, value)
+	if err != nil {
+		return nil, err
+	}
+	return date.UTC().Format(time.RFC3339Nano), nil
+}
+
+// Converts a column value to a field type, empty numbers become null
+func convertValue(value, typ string) (interface{}, error) {
+	trimmed := strings.TrimSpace(value)
+	switch typ {
+	case "int":
+		if trimmed == "" {
+			return nil, nil
+		}
+		return strconv.ParseInt(trimmed, 10, 64)
+	case "float":
+		if trimmed == "" {
+			return nil, nil
+		}
+		return strconv.ParseFloat(trimmed, 64)
+	case "bool":
+		return parseBool(value)
+	}
+	return value, nil
+}
+
+// Parses a boolean column, accepting true/false, 1/0 and yes/no in any case.
+// Empty values are false.
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "1", "yes", "y", "t":
+		return true, nil
+	case "false", "0", "no", "n", "f", "":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean %q", value)
+}
+
+// Splits a multi-value column, trimming the values and dropping empty ones
+func splitValues(value, sep string) []string {
+	values := []string{}
+	for _, v := range strings.Split(value, sep) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// Parses a point in the -latlng-format into latitude and longitude
+func (b *DefaultDocumentBuilder) parseLatLng(value string) (float64, float64, error) {
+	var lat, lon float64
+	var err error
+	switch b.cfg.LatLngFormat {
+	case "latlon":
+		lat, lon, err = parseLatLonString(value)
+	case "geojson":
+		lat, lon, err = parseGeoJSONPoint(value)
+	default:
+		lat, lon, err = b.parseWKTPoint(value)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if lat < -90 || lat > 90 {
+		return 0, 0, fmt.Errorf("latitude %g out of range [-90, 90]", lat)
+	}
+	if lon < -180 || lon > 180 {
+		return 0, 0, fmt.Errorf("longitude %g out of range [-180, 180]", lon)
+	}
+	return lat, lon, nil
+}
+
+// Parses a WKT point, honoring the coordinate order
+func (b *DefaultDocumentBuilder) parseWKTPoint(value string) (float64, float64, error) {
+	matches := latlngRegex.FindStringSubmatch(value)
+	if len(matches) != 3 {
+		return 0, 0, fmt.Errorf("invalid point %q", value)
+	}
+
+	lonMatch, latMatch := matches[1], matches[2]
+	if b.cfg.CoordOrder == "latlon" {
+		lonMatch, latMatch = latMatch, lonMatch
+	}
+
+	lon, err := strconv.ParseFloat(lonMatch, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid longitude in %q: %w", value, err)
+	}
+	lat, err := strconv.ParseFloat(latMatch, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid latitude in %q: %w", value, err)
+	}
+	return lat, lon, nil
+}
+
+// Parses a "lat,lon" string
+func parseLatLonString(value string) (float64, float64, error) {
+	latValue, lonValue, ok := strings.Cut(value, ",")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid point %q, expected lat,lon", value)
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(latValue), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid latitude in %q: %w", value, err)
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(lonValue), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid longitude in %q: %w", value, err)
+	}
+	return lat, lon, nil
+}
+
+// Parses a GeoJSON Point object, its coordinates being longitude first
+func parseGeoJSONPoint(value string) (float64, float64, error) {
+	var point struct {
+		Type        string    `json:"type"`
+		Coordinates []float64 `json:"coordinates"`
+	}
+	if err := json.Unmarshal([]byte(value), &point); err != nil {
+		return 0, 0, fmt.Errorf("invalid GeoJSON point %q: %w", value, err)
+	}
+	if point.Type != "Point" || len(point.Coordinates) < 2 {
+		return 0, 0, fmt.Errorf("invalid GeoJSON point %q, expected a Point with coordinates", value)
+	}
+	return point.Coordinates[1], point.Coordinates[0], nil
+}
@@ -0,0 +1,348 @@
+package eslocationseed
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Builds the document of a CSV row with the test header, mapping the columns
+// the way the importer does
+func buildTestDocument(t *testing.T, cfg Config, row string) (map[string]interface{}, error) {
+	t.Helper()
+	record, err := csv.NewReader(strings.NewReader(row)).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	positions := headerPositions(strings.Split(testHeader, ","))
+	var columns Columns
+	if columns.Fields, err = mapColumns(positions, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if columns.Bools, err = mapBoolColumns(positions, columns.Fields, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if columns.Dates, err = mapDateColumns(positions, columns.Fields, cfg); err != nil {
+		t.Fatal(err)
+	}
+	return NewDocumentBuilder(cfg).BuildDocument(record, columns)
+}
+
+func TestParseLatLngNegativeCoordinates(t *testing.T) {
+	b := NewDocumentBuilder(DefaultConfig())
+	tests := []struct {
+		name     string
+		value    string
+		lat, lon float64
+	}{
+		{"negative latitude", "POINT (151.2093 -33.8688)", -33.8688, 151.2093},
+		{"negative longitude", "POINT (-122.4 37.7)", 37.7, -122.4},
+		{"both negative", "POINT (-58.3816 -34.6037)", -34.6037, -58.3816},
+		{"integers", "POINT (-122 -37)", -37, -122},
+	}
+	for _, tt := range tests {
+		lat, lon, err := b.parseLatLng(tt.value)
+		if err != nil {
+			t.Fatalf("%s: parseLatLng(%q): %v", tt.name, tt.value, err)
+		}
+		if lat != tt.lat || lon != tt.lon {
+			t.Errorf("%s: parseLatLng(%q) = %g, %g, want %g, %g", tt.name, tt.value, lat, lon, tt.lat, tt.lon)
+		}
+	}
+}
+
+func TestParseLatLngCoordinateRanges(t *testing.T) {
+	b := NewDocumentBuilder(DefaultConfig())
+	tests := []struct {
+		value string
+		valid bool
+	}{
+		{"POINT (0 90)", true},
+		{"POINT (0 -90)", true},
+		{"POINT (180 0)", true},
+		{"POINT (-180 0)", true},
+		{"POINT (180 90)", true},
+		{"POINT (0 90.0001)", false},
+		{"POINT (0 -90.0001)", false},
+		{"POINT (180.0001 0)", false},
+		{"POINT (-180.0001 0)", false},
+		{"POINT (0 200)", false},
+		{"POINT (200 0)", false},
+		{"POINT (500 -200)", false},
+	}
+	for _, tt := range tests {
+		_, _, err := b.parseLatLng(tt.value)
+		if tt.valid && err != nil {
+			t.Errorf("parseLatLng(%q): %v", tt.value, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("parseLatLng(%q) succeeded, want an out of range error", tt.value)
+		}
+	}
+}
+
+func TestParseLatLngCoordinateOrder(t *testing.T) {
+	// Dhaka, whose latitude and longitude are both valid either way around
+	const lat, lon = 23.8103, 90.4125
+	tests := []struct {
+		order string
+		value string
+	}{
+		{"lonlat", "POINT (90.4125 23.8103)"},
+		{"latlon", "POINT (23.8103 90.4125)"},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.CoordOrder = tt.order
+		b := NewDocumentBuilder(cfg)
+		gotLat, gotLon, err := b.parseLatLng(tt.value)
+		if err != nil {
+			t.Fatalf("%s: parseLatLng(%q): %v", tt.order, tt.value, err)
+		}
+		if gotLat != lat || gotLon != lon {
+			t.Errorf("%s: parseLatLng(%q) = %g, %g, want %g, %g", tt.order, tt.value, gotLat, gotLon, lat, lon)
+		}
+	}
+}
+
+func TestSplitValues(t *testing.T) {
+	tests := []struct {
+		value, sep string
+		want       []string
+	}{
+		{"road;street", ";", []string{"road", "street"}},
+		{" road ; street ;", ";", []string{"road", "street"}},
+		{"road|street|", "|", []string{"road", "street"}},
+		{"road | | street", "|", []string{"road", "street"}},
+		{"road, street,,", ",", []string{"road", "street"}},
+		{"road;street", ",", []string{"road;street"}},
+		{"", ";", []string{}},
+		{" ; ", ";", []string{}},
+	}
+	for _, tt := range tests {
+		if got := splitValues(tt.value, tt.sep); !slices.Equal(got, tt.want) || got == nil {
+			t.Errorf("splitValues(%q, %q) = %q, want %q", tt.value, tt.sep, got, tt.want)
+		}
+	}
+}
+
+func TestBuildDocumentGeometry(t *testing.T) {
+	dhaka := map[string]interface{}{"lat": 23.8103, "lon": 90.4125}
+	tests := []struct {
+		name     string
+		geometry string
+		want     interface{}
+	}{
+		{"2D point", "POINT (90.4125 23.8103)", dhaka},
+		{"no space", "POINT(90.4125 23.8103)", dhaka},
+		{"Z point", "POINT Z (90.4125 23.8103 12.5)", dhaka},
+		{"ZM point", "POINT ZM (90.4125 23.8103 12.5 3)", dhaka},
+		{"empty", "", nil},
+		{"blank", "  ", nil},
+		{"empty point", "POINT EMPTY", nil},
+		{"empty Z point", "POINT Z EMPTY", nil},
+		{"empty M point", "POINT M EMPTY", nil},
+		{"empty ZM point", "POINT ZM EMPTY", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row := strings.Replace(testRow(1), "POINT (90.4125 23.8103)", tt.geometry, 1)
+			document, err := buildTestDocument(t, DefaultConfig(), row)
+			if err != nil {
+				t.Fatal(err)
+			}
+			location, ok := document["latlng"]
+			if tt.want == nil {
+				if ok {
+					t.Errorf("latlng = %v, want the field omitted", location)
+				}
+				return
+			}
+			if !reflect.DeepEqual(location, tt.want) {
+				t.Errorf("latlng = %v, want %v", location, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseWKTPointRejectsSurroundingText(t *testing.T) {
+	b := NewDocumentBuilder(DefaultConfig())
+	for _, value := range []string{
+		"POINT (90.4125 23.8103) POINT (0 0)",
+		"MULTIPOINT (90.4125 23.8103)",
+		"POINT (90.4125 23.8103))",
+		"x POINT (90.4125 23.8103)",
+	} {
+		if lat, lon, err := b.parseWKTPoint(value); err == nil {
+			t.Errorf("parseWKTPoint(%q) = %g, %g, want an error", value, lat, lon)
+		}
+	}
+	if _, _, err := b.parseWKTPoint("  POINT (90.4125 23.8103)  "); err != nil {
+		t.Errorf("parseWKTPoint with surrounding spaces: %v", err)
+	}
+}
+
+func TestCheckPlusCode(t *testing.T) {
+	valid := []string{
+		"",
+		"7MMG+XX",
+		"7MMGVJ8G+2V",
+		"7mmgvj8g+2v",
+		" 7MMGVJ8G+2VX ",
+		"7MMG0000+",
+		"7MMGVJ00+",
+		"7M000000+",
+		"VJ8G+2V",
+		"8G+2V",
+		"7MMGVJ8G+2VXXXXX",
+	}
+	for _, code := range valid {
+		if err := checkPlusCode(code); err != nil {
+			t.Errorf("checkPlusCode(%q): %v", code, err)
+		}
+	}
+
+	invalid := []string{
+		"7MMGVJ8G",
+		"7MMG+XX+",
+		"7MMGVJ8G2V+",
+		"7MM+GXX",
+		"7MMGVJ8G+2",
+		"7MMGVJ8G+2A",
+		"7MMGVJ8I+2V",
+		"0MMG0000+",
+		"7MMG000+",
+		"7MM00000+",
+		"7MMG00VJ+",
+		"7MMG0000+2V",
+		"VJ00+",
+		"+",
+		"+2V",
+		"VJ8G+",
+		"7MMGVJ8G+2VXXXXXX",
+	}
+	for _, code := range invalid {
+		if err := checkPlusCode(code); err == nil {
+			t.Errorf("checkPlusCode(%q) succeeded, want an error", code)
+		}
+	}
+}
+
+func TestBuildDocumentGeoType(t *testing.T) {
+	tests := []struct {
+		geoType string
+		want    string
+	}{
+		{"point", `{"lat":23.8103,"lon":90.4125}`},
+		{"shape", `{"coordinates":[90.4125,23.8103],"type":"Point"}`},
+		{"wkt", `"POINT (90.4125 23.8103)"`},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.GeoType = tt.geoType
+		document, err := buildTestDocument(t, cfg, testRow(1))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.geoType, err)
+		}
+		got, err := json.Marshal(document["latlng"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: latlng = %s, want %s", tt.geoType, got, tt.want)
+		}
+	}
+}
+
+func TestBuildDocumentNullValues(t *testing.T) {
+	row := `1,Road 1,Dhaka,BD,NULL,,\N,POINT (90.4125 23.8103),p1, NULL ,\N,road`
+	tests := []struct {
+		name       string
+		nullValues []string
+		omitted    []string
+	}{
+		{"none", nil, nil},
+		{"backslash N", []string{`\N`}, []string{"isAutocompleteAddress", "postalCode"}},
+		{"NULL", []string{"NULL"}, []string{"district", "plusCode"}},
+		{"empty string", []string{""}, []string{"division"}},
+		{"all", []string{`\N`, "NULL", ""}, []string{"district", "division", "isAutocompleteAddress", "plusCode", "postalCode"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.NullValues = tt.nullValues
+			if !slices.Contains(tt.nullValues, `\N`) {
+				// \N is not a boolean unless it is a null value
+				cfg.BoolFields = nil
+			}
+			document, err := buildTestDocument(t, cfg, row)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, field := range []string{"address", "city", "country", "district", "division", "isAutocompleteAddress", "latlng", "placeId", "plusCode", "postalCode", "types"} {
+				_, ok := document[field]
+				if omitted := slices.Contains(tt.omitted, field); ok == omitted {
+					t.Errorf("field %s present = %t, want %t", field, ok, !omitted)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildDocumentLatLngFormats(t *testing.T) {
+	values := map[string]string{
+		"wkt":     "POINT (90.4125 23.8103)",
+		"latlon":  `"23.8103, 90.4125"`,
+		"geojson": `"{""type"":""Point"",""coordinates"":[90.4125,23.8103]}"`,
+	}
+	for _, geoType := range []string{"point", "shape"} {
+		cfg := DefaultConfig()
+		cfg.GeoType = geoType
+		want, err := buildTestDocument(t, cfg, testRow(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for format, value := range values {
+			cfg.LatLngFormat = format
+			row := strings.Replace(testRow(1), "POINT (90.4125 23.8103)", value, 1)
+			got, err := buildTestDocument(t, cfg, row)
+			if err != nil {
+				t.Fatalf("%s %s: %v", format, geoType, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s %s: document = %v, want %v", format, geoType, got, want)
+			}
+		}
+	}
+}
+
+func TestValidateTransformFields(t *testing.T) {
+	tests := []struct {
+		field string
+		valid bool
+	}{
+		{"city", true},
+		{"isAutocompleteAddress", true},
+		{"verified", true},
+		{"cty", false},
+		{"id", false},
+		{"latlng", false},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.ESURL = "http://localhost:9200"
+		cfg.Index = "locations"
+		cfg.BoolFields = append(cfg.BoolFields, "verified")
+		cfg.Transforms = map[string][]string{tt.field: {"trim"}}
+		err := cfg.Validate()
+		if tt.valid && err != nil {
+			t.Errorf("-transform %s=trim: %v", tt.field, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("-transform %s=trim accepted, want an error", tt.field)
+		}
+	}
+}
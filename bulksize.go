@@ -0,0 +1,47 @@
+package eslocationseed
+
+import (
+	"context"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+)
+
+// Bulk indexer flushing after a number of added items, on top of the size and
+// interval flushes of the bulk indexer. The bulk indexer has no flush of its
+// own, so it is closed, which sends what its workers hold, and replaced.
+type countIndexer struct {
+	esutil.BulkIndexer
+	open     func() (esutil.BulkIndexer, error)
+	bulkSize int
+	added    int
+}
+
+// Opens a bulk indexer that is flushed every bulkSize items
+func newCountIndexer(open func() (esutil.BulkIndexer, error), bulkSize int) (*countIndexer, error) {
+	bi, err := open()
+	if err != nil {
+		return nil, err
+	}
+	return &countIndexer{BulkIndexer: bi, open: open, bulkSize: bulkSize}, nil
+}
+
+// Adds an item, flushing once bulkSize items were added since the last flush
+func (c *countIndexer) Add(ctx context.Context, item esutil.BulkIndexerItem) error {
+	if err := c.BulkIndexer.Add(ctx, item); err != nil {
+		return err
+	}
+	c.added++
+	if c.added < c.bulkSize {
+		return nil
+	}
+	if err := c.BulkIndexer.Close(ctx); err != nil {
+		return err
+	}
+	bi, err := c.open()
+	if err != nil {
+		return err
+	}
+	c.BulkIndexer = bi
+	c.added = 0
+	return nil
+}
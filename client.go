@@ -0,0 +1,174 @@
+package eslocationseed
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// Creates the Elasticsearch client and checks the cluster is reachable, the
+// bulk requests being copied to the mirrors
+func newClient(ctx context.Context, cfg Config, throttle *throttle, mirrors []*mirror) (*elasticsearch.Client, error) {
+	esConfig := elasticsearch.Config{
+		RetryOnStatus: retryStatuses,
+		MaxRetries:    cfg.MaxRetries,
+		DisableRetry:  cfg.MaxRetries == 0,
+		// Back off at least as long as the throttle while the cluster is under pressure
+		RetryBackoff: func(attempt int) time.Duration {
+			return max(retryDelay(attempt), throttle.current())
+		},
+
+		CompressRequestBody: cfg.CompressRequests,
+	}
+	if cfg.CloudID != "" {
+		esConfig.CloudID = cfg.CloudID
+	} else {
+		esConfig.Addresses = []string{cfg.ESURL}
+	}
+	if cfg.APIKey != "" {
+		if cfg.Username != "" || cfg.Password != "" {
+			slog.Warn("Both an API key and basic auth credentials are set, using the API key")
+		}
+		esConfig.APIKey = cfg.APIKey
+	} else {
+		esConfig.Username = cfg.Username
+		esConfig.Password = cfg.Password
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	// Over HTTP/1.1 every concurrent bulk request needs a connection of its
+	// own. Keeping one idle connection per worker, plus one for the tracker and
+	// refresh requests, lets them be reused instead of reopened, which costs a
+	// TLS handshake per batch on high-latency links. Over HTTP/2 the workers
+	// multiplex their requests on a single connection.
+	transport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	if transport.MaxIdleConnsPerHost == 0 {
+		transport.MaxIdleConnsPerHost = cfg.Workers + 1
+	}
+	transport.MaxIdleConns = max(transport.MaxIdleConns, transport.MaxIdleConnsPerHost)
+	transport.IdleConnTimeout = cfg.IdleConnTimeout
+	transport.DisableKeepAlives = !cfg.KeepAlive
+	transport.ForceAttemptHTTP2 = cfg.HTTP2
+	if !cfg.HTTP2 {
+		// A non-nil empty map keeps the transport from upgrading to HTTP/2
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	// Honor HTTP_PROXY, HTTPS_PROXY and NO_PROXY unless a proxy is given
+	transport.Proxy = http.ProxyFromEnvironment
+	if cfg.ProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing proxy URL: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	// The CA is trusted on the transport itself, the client only accepts
+	// one for a bare *http.Transport and ours is wrapped
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	if cfg.CACert != "" {
+		cert, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA certificate: %w", err)
+		}
+		transport.TLSClientConfig.RootCAs = x509.NewCertPool()
+		if !transport.TLSClientConfig.RootCAs.AppendCertsFromPEM(cert) {
+			return nil, fmt.Errorf("error reading CA certificate: no PEM certificate in %s", cfg.CACert)
+		}
+	}
+	if cfg.Insecure {
+		slog.Warn("!!! TLS certificate verification is disabled, the connection to Elasticsearch is not secure. Never use -es-insecure in production !!!")
+		transport.TLSClientConfig.InsecureSkipVerify = true
+	}
+	esConfig.Transport = throttleTransport{RoundTripper: transport, throttle: throttle}
+	if len(mirrors) > 0 {
+		esConfig.Transport = mirrorTransport{RoundTripper: esConfig.Transport, mirrors: mirrors, retries: newRetryCounter(cfg.MaxRetries)}
+	}
+	es, err := elasticsearch.NewClient(esConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Elasticsearch client: %w", err)
+	}
+
+	if err := waitForCluster(ctx, es, cfg.WaitForCluster); err != nil {
+		return nil, err
+	}
+	return es, nil
+}
+
+// Pings Elasticsearch until it responds, retrying connection errors and
+// server errors with backoff for up to maxWait
+func waitForCluster(ctx context.Context, es *elasticsearch.Client, maxWait time.Duration) error {
+	if maxWait == 0 {
+		return ping(ctx, es)
+	}
+	ctx, cancel := context.WithTimeout(ctx, maxWait)
+	defer cancel()
+
+	for attempt := 1; ; attempt++ {
+		err := ping(ctx, es)
+		var statusErr *pingError
+		if err == nil || (errors.As(err, &statusErr) && statusErr.status < http.StatusInternalServerError) {
+			return err
+		}
+
+		delay := retryDelay(attempt)
+		deadline, _ := ctx.Deadline()
+		if time.Until(deadline) < delay {
+			return err
+		}
+		slog.Info("Waiting for Elasticsearch", "attempt", attempt, "error", err, "retry_in", delay.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Error response to a ping
+type pingError struct {
+	status int
+	body   string
+}
+
+func (e *pingError) Error() string {
+	return fmt.Sprintf("elasticsearch returned an error: %s", e.body)
+}
+
+// Checks Elasticsearch is reachable
+func ping(ctx context.Context, es *elasticsearch.Client) error {
+	res, err := es.Info(es.Info.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error pinging Elasticsearch: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return &pingError{status: res.StatusCode, body: res.String()}
+	}
+	return nil
+}
+
+// Returns the exponential backoff delay with jitter for a retry attempt
+func retryDelay(attempt int) time.Duration {
+	// Doubling stops at the maximum, shifting further would overflow
+	delay := retryBaseDelay
+	for i := 1; i < attempt && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, retryMaxDelay)
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
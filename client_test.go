@@ -0,0 +1,15 @@
+package eslocationseed
+
+import "testing"
+
+func TestRetryDelayStaysWithinBounds(t *testing.T) {
+	for _, attempt := range []int{1, 2, 6, 7, 35, 36, 40, 64, 1000} {
+		delay := retryDelay(attempt)
+		if delay <= 0 || delay > retryMaxDelay {
+			t.Errorf("retryDelay(%d) = %s, want within (0, %s]", attempt, delay, retryMaxDelay)
+		}
+	}
+	if delay := retryDelay(1); delay > retryBaseDelay {
+		t.Errorf("retryDelay(1) = %s, want at most %s", delay, retryBaseDelay)
+	}
+}
@@ -0,0 +1,43 @@
+// Command eslocationseed imports location CSV and NDJSON files into Elasticsearch, see
+// -help for its flags
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"EsLocationSeed"
+)
+
+func main() {
+	cfg, err := eslocationseed.LoadConfig()
+	if err != nil {
+		usageError("%s", err)
+	}
+
+	// Cancel the import on interrupt, a second signal kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	// An interrupted import has saved its progress, so it is not a failure
+	if err := eslocationseed.Run(ctx, cfg); err != nil && !errors.Is(err, context.Canceled) {
+		slog.Error("Import failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+// Prints the error followed by the flag usage and exits
+func usageError(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "Error: "+format+"\n\n", args...)
+	flag.Usage()
+	os.Exit(2)
+}
@@ -0,0 +1,1159 @@
+// Package eslocationseed imports location CSV and NDJSON files into
+// Elasticsearch. The eslocationseed command configures it from flags with
+// LoadConfig; programs embedding it start from DefaultConfig, set the fields
+// they need, and call Run, optionally with a DocumentBuilder of their own.
+package eslocationseed
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+)
+
+var (
+	// HTTP statuses worth retrying a bulk request for
+	retryStatuses = []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+	// Default mapping of logical field names to CSV column headers
+	defaultColumnNames = map[string]string{
+		"id":                    "id",
+		"address":               "address",
+		"city":                  "city",
+		"country":               "country",
+		"district":              "district",
+		"division":              "division",
+		"isAutocompleteAddress": "isAutocompleteAddress",
+		"latlng":                "latlng",
+		"placeId":               "placeId",
+		"plusCode":              "plusCode",
+		"postalCode":            "postalCode",
+		"types":                 "types",
+	}
+
+	// Matches WKT points such as "POINT (-122.4 37.7)", lon first, with
+	// optional Z and M coordinates that are ignored
+	latlngRegex = regexp.MustCompile(`^\s*POINT\s*(?:ZM|Z|M)?\s*\(\s*(-?\d+\.?\d*)\s+(-?\d+\.?\d*)(?:\s+-?\d+\.?\d*){0,2}\s*\)\s*$`)
+
+	// Matches the empty WKT points, indexed without a geometry
+	emptyPointRegex = regexp.MustCompile(`^\s*POINT\s*(?:ZM|Z|M)?\s*EMPTY\s*$`)
+)
+
+// Settings of an import run
+type Config struct {
+	ESURL                string
+	MirrorURLs           []string
+	CloudID              string
+	Index                string
+	Pipeline             string
+	APIKey               string
+	Username             string
+	Password             string
+	CACert               string
+	Insecure             bool
+	ProxyURL             string
+	CSVFiles             []string
+	FlushBytes           int
+	BulkSize             int
+	MaxRequestBytes      int
+	FlushInterval        time.Duration
+	SkipBadRows          bool
+	MaxErrors            int
+	ItemRetries          int
+	DryRun               bool
+	CountOnly            bool
+	Check                bool
+	ExplainBatch         int
+	Inspect              bool
+	Upsert               bool
+	DetectNoop           bool
+	OpType               string
+	DedupBatch           bool
+	DetectDuplicateIDs   string
+	FailOnDuplicateIDs   bool
+	Delete               bool
+	Refresh              string
+	WaitForActiveShards  string
+	RefreshAfter         bool
+	Verify               bool
+	PromoteAlias         string
+	DeleteOldIndex       bool
+	NoProgress           bool
+	Pretty               bool
+	LogEvery             int
+	Gzip                 bool
+	Delimiter            rune
+	InputFormat          string
+	LazyQuotes           bool
+	LenientRows          bool
+	CoordOrder           string
+	LatLngFormat         string
+	GeoType              string
+	ValidatePlusCode     bool
+	TypesSeparator       string
+	CreateIndex          bool
+	IndexMapping         string
+	TrackerFile          string
+	TrackerBackend       string
+	TrackerIndex         string
+	NoTracker            bool
+	ResetTracker         bool
+	CheckpointInterval   time.Duration
+	DeadLetterFile       string
+	Report               string
+	MetricsAddr          string
+	IDFields             []string
+	IDStrategy           string
+	IDSeparator          string
+	IDEncoding           string
+	IDOriginalField      string
+	RoutingField         string
+	TimestampField       string
+	Since                time.Time
+	VersionField         string
+	VersionType          string
+	BoolFields           []string
+	DateFields           []string
+	DateLayout           string
+	IngestTimestamp      bool
+	KeepRaw              string
+	FieldTypes           map[string]string
+	Transforms           map[string][]string
+	Lookups              []fieldLookup
+	LookupUnmapped       string
+	ConstantFields       map[string]string
+	NullValues           []string
+	FieldNames           map[string]string
+	FieldsInclude        []string
+	FieldsExclude        []string
+	ExpectedHeader       []string
+	Filters              []string
+	IgnoreHeaderMismatch bool
+	Workers              int
+	MaxRetries           int
+	ThrottleInitial      time.Duration
+	ThrottleMin          time.Duration
+	ThrottleMax          time.Duration
+	CompressRequests     bool
+	RequestTimeout       time.Duration
+	MaxIdleConnsPerHost  int
+	IdleConnTimeout      time.Duration
+	KeepAlive            bool
+	HTTP2                bool
+	WaitForCluster       time.Duration
+	Limit                int
+
+	// Maps logical field names to CSV column headers
+	Columns map[string]string
+
+	// Builds the documents from the CSV records, the default builder when nil.
+	// Not settable from the command line, for embedders calling Run.
+	Builder DocumentBuilder
+}
+
+// Returns the configuration used when no flags or environment variables are set
+func DefaultConfig() Config {
+	return Config{
+		FlushBytes:         5 << 20,
+		FlushInterval:      30 * time.Second,
+		MaxRequestBytes:    90 << 20,
+		Refresh:            "false",
+		LogEvery:           10000,
+		Delimiter:          ',',
+		CoordOrder:         "lonlat",
+		LatLngFormat:       "wkt",
+		TypesSeparator:     ";",
+		CreateIndex:        true,
+		IDSeparator:        "_",
+		IDStrategy:         "column",
+		IDEncoding:         "raw",
+		DetectNoop:         true,
+		IDOriginalField:    "originalId",
+		OpType:             "index",
+		LookupUnmapped:     "pass",
+		TrackerBackend:     "file",
+		TrackerIndex:       "eslocationseed-trackers",
+		InputFormat:        "csv",
+		ThrottleInitial:    time.Second,
+		ThrottleMax:        30 * time.Second,
+		GeoType:            "point",
+		VersionType:        "external",
+		CheckpointInterval: 10 * time.Second,
+		DateLayout:         time.RFC3339,
+		BoolFields:         []string{"isAutocompleteAddress"},
+		Workers:            1,
+		MaxRetries:         3,
+		RequestTimeout:     30 * time.Second,
+		IdleConnTimeout:    90 * time.Second,
+		KeepAlive:          true,
+		HTTP2:              true,
+		WaitForCluster:     30 * time.Second,
+		Columns:            maps.Clone(defaultColumnNames),
+	}
+}
+
+// Reads configuration from the command-line flags of the process, falling
+// back to environment variables, and validates it
+func LoadConfig() (Config, error) {
+	// Load environment variables, the .env file is optional
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+		return Config{}, fmt.Errorf("error loading .env file: %w", err)
+	}
+
+	cfg := DefaultConfig()
+	env := &envReader{}
+	var csvFiles stringList
+	profile := flag.String("profile", os.Getenv("PROFILE"), "bundle of defaults for the bulk requests: fast disables refresh and sends large batches with 4 workers, safe waits for all shard copies and for refresh with small batches; flags, environment variables and -config settings override it (env PROFILE)")
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML or JSON file of settings keyed by flag name, overridden by flags and environment variables (env CONFIG_FILE)")
+	var filters stringList
+	var transforms stringList
+	var lookups stringList
+	var constants stringList
+	flag.StringVar(&cfg.ESURL, "es-url", os.Getenv("ES_URL"), "Elasticsearch URL (env ES_URL)")
+	mirrors := flag.String("mirror-urls", os.Getenv("MIRROR_URLS"), "comma-separated URLs of clusters receiving a copy of every bulk request, with the same credentials unless given in the URL; their failures are reported without aborting the import (env MIRROR_URLS)")
+	flag.StringVar(&cfg.CloudID, "es-cloud-id", os.Getenv("ES_CLOUD_ID"), "Elastic Cloud ID, used instead of -es-url (env ES_CLOUD_ID)")
+	flag.StringVar(&cfg.APIKey, "es-api-key", os.Getenv("ES_API_KEY"), "Elasticsearch API key (env ES_API_KEY)")
+	flag.StringVar(&cfg.Username, "es-username", os.Getenv("ES_USERNAME"), "Elasticsearch basic auth username (env ES_USERNAME)")
+	flag.StringVar(&cfg.Password, "es-password", os.Getenv("ES_PASSWORD"), "Elasticsearch basic auth password (env ES_PASSWORD)")
+	flag.StringVar(&cfg.CACert, "es-ca-cert", os.Getenv("ES_CA_CERT"), "PEM file with the CA certificate to trust for Elasticsearch (env ES_CA_CERT)")
+	flag.StringVar(&cfg.ProxyURL, "proxy-url", os.Getenv("ES_PROXY_URL"), "proxy for the Elasticsearch connection, overriding HTTP_PROXY, HTTPS_PROXY and NO_PROXY (env ES_PROXY_URL)")
+	flag.BoolVar(&cfg.Insecure, "es-insecure", env.bool("ES_INSECURE", false), "skip TLS certificate verification, for development clusters only (env ES_INSECURE)")
+	flag.StringVar(&cfg.Index, "es-index", os.Getenv("ES_INDEX"), "target Elasticsearch index, {field} placeholders such as locations-{country} name the index after each row (env ES_INDEX)")
+	flag.StringVar(&cfg.Pipeline, "pipeline", os.Getenv("ES_PIPELINE"), "ingest pipeline to run documents through (env ES_PIPELINE)")
+	flag.Var(&csvFiles, "csv", "path or glob pattern of CSV files to import, - reads stdin, repeatable, more may follow as arguments (env CSV_FILE)")
+	flag.IntVar(&cfg.FlushBytes, "flush-bytes", env.int("FLUSH_BYTES", cfg.FlushBytes), "flush a bulk request once it reaches this many bytes (env FLUSH_BYTES)")
+	flag.IntVar(&cfg.BulkSize, "bulk-size", env.int("BULK_SIZE", cfg.BulkSize), "also flush the pending documents after this many, 0 flushes by -flush-bytes only (env BULK_SIZE)")
+	flag.IntVar(&cfg.MaxRequestBytes, "max-request-bytes", env.int("MAX_REQUEST_BYTES", cfg.MaxRequestBytes), "largest bulk request Elasticsearch accepts (http.max_content_length), larger documents are skipped (env MAX_REQUEST_BYTES)")
+	flag.DurationVar(&cfg.FlushInterval, "flush-interval", env.duration("FLUSH_INTERVAL", cfg.FlushInterval), "flush pending documents at least this often (env FLUSH_INTERVAL)")
+	flag.StringVar(&cfg.TrackerBackend, "tracker-backend", envString("TRACKER_BACKEND", cfg.TrackerBackend), "where to keep the resume state: file, or es for a document per CSV file path, relative to the working directory, in -tracker-index shared by every machine (env TRACKER_BACKEND)")
+	flag.StringVar(&cfg.TrackerIndex, "tracker-index", envString("TRACKER_INDEX", cfg.TrackerIndex), "index holding the resume state with -tracker-backend es (env TRACKER_INDEX)")
+	flag.StringVar(&cfg.TrackerFile, "tracker-file", os.Getenv("TRACKER_FILE"), "resume tracker path, defaults to <csv name>_last_id_tracker.json next to the CSV file (env TRACKER_FILE)")
+	flag.BoolVar(&cfg.NoTracker, "no-tracker", env.bool("NO_TRACKER", false), "ignore any tracker and do not write one, always importing from the first row (env NO_TRACKER)")
+	flag.BoolVar(&cfg.ResetTracker, "reset-tracker", false, "delete the trackers of the CSV files and exit")
+	flag.DurationVar(&cfg.CheckpointInterval, "checkpoint-interval", env.duration("CHECKPOINT_INTERVAL", cfg.CheckpointInterval), "save the progress to the tracker at least this often, besides after every batch, 0 saves per batch only (env CHECKPOINT_INTERVAL)")
+	flag.StringVar(&cfg.DeadLetterFile, "dead-letter", os.Getenv("DEAD_LETTER_FILE"), "file to append the IDs of documents rejected by Elasticsearch (env DEAD_LETTER_FILE)")
+	flag.IntVar(&cfg.Workers, "workers", env.int("WORKERS", cfg.Workers), "number of concurrent bulk requests (env WORKERS)")
+	flag.DurationVar(&cfg.RequestTimeout, "request-timeout", env.duration("REQUEST_TIMEOUT", cfg.RequestTimeout), "time to wait for a bulk request to complete, its retries included, before failing the import, 0 waits forever (env REQUEST_TIMEOUT)")
+	flag.IntVar(&cfg.MaxIdleConnsPerHost, "max-idle-conns-per-host", env.int("MAX_IDLE_CONNS_PER_HOST", 0), "idle connections kept open per Elasticsearch node for reuse, 0 keeps one per worker plus one; fewer than -workers makes concurrent bulk requests over HTTP/1.1 open new connections (env MAX_IDLE_CONNS_PER_HOST)")
+	flag.DurationVar(&cfg.IdleConnTimeout, "idle-conn-timeout", env.duration("IDLE_CONN_TIMEOUT", cfg.IdleConnTimeout), "time an idle connection is kept open, 0 keeps it until the server closes it (env IDLE_CONN_TIMEOUT)")
+	flag.BoolVar(&cfg.KeepAlive, "keep-alive", env.bool("KEEP_ALIVE", cfg.KeepAlive), "reuse connections between requests, disabling opens one per request (env KEEP_ALIVE)")
+	flag.BoolVar(&cfg.HTTP2, "http2", env.bool("HTTP2", cfg.HTTP2), "negotiate HTTP/2 over TLS so all workers share one multiplexed connection; plain http:// URLs always use HTTP/1.1 (env HTTP2)")
+	flag.DurationVar(&cfg.WaitForCluster, "wait-for-cluster", env.duration("WAIT_FOR_CLUSTER", cfg.WaitForCluster), "keep retrying the initial connection to Elasticsearch for up to this long (env WAIT_FOR_CLUSTER)")
+	flag.BoolVar(&cfg.CompressRequests, "compress-requests", env.bool("COMPRESS_REQUESTS", false), "gzip the bulk request bodies, typically several times smaller at the cost of CPU, worth it over slow links (env COMPRESS_REQUESTS)")
+	flag.DurationVar(&cfg.ThrottleInitial, "throttle-initial", env.duration("THROTTLE_INITIAL", cfg.ThrottleInitial), "delay between bulk requests after the first 429 rejection, doubled on every further one (env THROTTLE_INITIAL)")
+	flag.DurationVar(&cfg.ThrottleMin, "throttle-min", env.duration("THROTTLE_MIN", cfg.ThrottleMin), "delay between bulk requests once Elasticsearch recovered (env THROTTLE_MIN)")
+	flag.DurationVar(&cfg.ThrottleMax, "throttle-max", env.duration("THROTTLE_MAX", cfg.ThrottleMax), "longest delay between bulk requests under pressure (env THROTTLE_MAX)")
+	flag.IntVar(&cfg.MaxRetries, "max-retries", env.int("MAX_RETRIES", cfg.MaxRetries), "retries for failed bulk requests before giving up (env MAX_RETRIES)")
+	columns := flag.String("columns", os.Getenv("CSV_COLUMNS"), "comma-separated field=header overrides for the CSV column mapping (env CSV_COLUMNS)")
+	bools := flag.String("bool-fields", envString("BOOL_FIELDS", strings.Join(cfg.BoolFields, ",")), "comma-separated fields indexed as booleans, either mapped fields or extra CSV columns (env BOOL_FIELDS)")
+	expected := flag.String("expected-header", os.Getenv("EXPECTED_HEADER"), "comma-separated column names the CSV header must contain, in any order (env EXPECTED_HEADER)")
+	flag.BoolVar(&cfg.IgnoreHeaderMismatch, "ignore-header-mismatch", env.bool("IGNORE_HEADER_MISMATCH", false), "only warn when the CSV header differs from -expected-header (env IGNORE_HEADER_MISMATCH)")
+	names := flag.String("field-names", os.Getenv("FIELD_NAMES"), "comma-separated field=name renames of the document fields, e.g. placeId=place_id (env FIELD_NAMES)")
+	include := flag.String("fields-include", os.Getenv("FIELDS_INCLUDE"), "comma-separated fields to keep in the documents, dropping all others (env FIELDS_INCLUDE)")
+	exclude := flag.String("fields-exclude", os.Getenv("FIELDS_EXCLUDE"), "comma-separated fields to drop from the documents (env FIELDS_EXCLUDE)")
+	dates := flag.String("date-fields", os.Getenv("DATE_FIELDS"), "comma-separated fields indexed as dates, either mapped fields or extra CSV columns (env DATE_FIELDS)")
+	flag.StringVar(&cfg.DateLayout, "date-layout", envString("DATE_LAYOUT", cfg.DateLayout), "Go time layout of the -date-fields values, dates without a zone are UTC (env DATE_LAYOUT)")
+	flag.BoolVar(&cfg.IngestTimestamp, "add-ingest-timestamp", env.bool("ADD_INGEST_TIMESTAMP", false), "add an ingestedAt field with the UTC time each document was queued (env ADD_INGEST_TIMESTAMP)")
+	flag.StringVar(&cfg.TimestampField, "timestamp-field", os.Getenv("TIMESTAMP_FIELD"), "CSV column holding the time each row was last updated, in the -date-layout, compared with -since (env TIMESTAMP_FIELD)")
+	since := flag.String("since", os.Getenv("SINCE"), "RFC3339 time such as the finished time of a previous run report, rows whose -timestamp-field is older are skipped (env SINCE)")
+	flag.StringVar(&cfg.KeepRaw, "keep-raw", os.Getenv("KEEP_RAW"), "document field to store the raw row in, its columns joined as in the CSV, for debugging; off by default as it grows the index (env KEEP_RAW)")
+	nulls := flag.String("null-values", os.Getenv("NULL_VALUES"), "comma-separated values such as \\N or NULL meaning a field is absent, omitted from the document after the transforms; an empty entry also omits empty values (env NULL_VALUES)")
+	types := flag.String("field-types", os.Getenv("FIELD_TYPES"), "comma-separated field=type conversions, type being string, int, float or bool (env FIELD_TYPES)")
+	flag.StringVar(&cfg.IDStrategy, "id-strategy", envString("ID_STRATEGY", cfg.IDStrategy), "how the document _id is formed: column uses the id column or -id-fields; hash-of-row uses the SHA-1 of the -id-fields, or of the whole row, so re-imports overwrite the same documents, but a changed value makes a new document; auto lets Elasticsearch assign IDs, so runs cannot be resumed and rows are never deduplicated, every run adding them again (env ID_STRATEGY)")
+	ids := flag.String("id-fields", os.Getenv("ID_FIELDS"), "comma-separated CSV columns joined to form the document _id, defaults to the id column (env ID_FIELDS)")
+	flag.StringVar(&cfg.RoutingField, "routing-field", os.Getenv("ROUTING_FIELD"), "CSV column whose value routes each document to its shard (env ROUTING_FIELD)")
+	flag.StringVar(&cfg.VersionField, "version-field", os.Getenv("VERSION_FIELD"), "CSV column holding an integer document version, older versions than indexed are counted as stale instead of failing (env VERSION_FIELD)")
+	flag.StringVar(&cfg.VersionType, "version-type", envString("VERSION_TYPE", cfg.VersionType), "version type of -version-field: external or external_gte (env VERSION_TYPE)")
+	flag.StringVar(&cfg.IDSeparator, "id-separator", envString("ID_SEPARATOR", cfg.IDSeparator), "separator placed between -id-fields values (env ID_SEPARATOR)")
+	flag.StringVar(&cfg.IDEncoding, "id-encoding", envString("ID_ENCODING", cfg.IDEncoding), "encoding of the _id values: raw, urlsafe for unpadded URL-safe base64, or sha1 for the hex SHA-1 digest; the original value is kept in -id-original-field (env ID_ENCODING)")
+	flag.StringVar(&cfg.IDOriginalField, "id-original-field", envString("ID_ORIGINAL_FIELD", cfg.IDOriginalField), "keyword field holding the original _id value when -id-encoding is not raw (env ID_ORIGINAL_FIELD)")
+	flag.IntVar(&cfg.ItemRetries, "item-retries", env.int("ITEM_RETRIES", 0), "times to resubmit the documents rejected by Elasticsearch after the file was sent, with backoff; only those still rejected go to the dead-letter file (env ITEM_RETRIES)")
+	flag.IntVar(&cfg.MaxErrors, "max-errors", env.int("MAX_ERRORS", 0), "abort once more than this many rows were skipped or rejected, 0 is unlimited (env MAX_ERRORS)")
+	flag.BoolVar(&cfg.SkipBadRows, "skip-bad-rows", env.bool("SKIP_BAD_ROWS", false), "log and skip rows that fail to parse instead of aborting (env SKIP_BAD_ROWS)")
+	flag.BoolVar(&cfg.CreateIndex, "create-index", env.bool("CREATE_INDEX", cfg.CreateIndex), "create the index with a geo_point mapping if it does not exist (env CREATE_INDEX)")
+	flag.StringVar(&cfg.IndexMapping, "index-mapping", os.Getenv("INDEX_MAPPING_FILE"), "JSON file with the settings and mappings used to create the index (env INDEX_MAPPING_FILE)")
+	flag.BoolVar(&cfg.Gzip, "gzip", env.bool("CSV_GZIP", false), "treat the CSV file as gzip-compressed, implied by a .gz extension (env CSV_GZIP)")
+	delimiter := flag.String("delimiter", envString("CSV_DELIMITER", ","), "single-character CSV field delimiter, use \\t for tabs (env CSV_DELIMITER)")
+	flag.StringVar(&cfg.InputFormat, "input-format", envString("INPUT_FORMAT", cfg.InputFormat), "format of the input files: csv, or ndjson with one JSON object per line whose dotted paths, as found in the first object, stand in for the CSV columns (env INPUT_FORMAT)")
+	flag.BoolVar(&cfg.LazyQuotes, "lazy-quotes", env.bool("CSV_LAZY_QUOTES", false), "allow unescaped quotes inside CSV fields; quoted fields may still span lines, but a field opened with a quote that is never closed then swallows the following rows (env CSV_LAZY_QUOTES)")
+	flag.BoolVar(&cfg.LenientRows, "lenient-rows", env.bool("CSV_LENIENT_ROWS", false), "accept rows with a different number of fields and skip blank rows (env CSV_LENIENT_ROWS)")
+	level := flag.String("log-level", envString("LOG_LEVEL", "info"), "log level: error, warn, info or debug, batches are logged at debug (env LOG_LEVEL)")
+	quiet := flag.Bool("quiet", env.bool("QUIET", false), "log errors only and hide the progress bar, leaving the final summary, overrides -log-level (env QUIET)")
+	verbose := flag.Bool("verbose", env.bool("VERBOSE", false), "log at debug level, with every batch and queued document, overrides -log-level (env VERBOSE)")
+	flag.BoolVar(verbose, "v", *verbose, "shorthand for -verbose")
+	logFormat := flag.String("log-format", envString("LOG_FORMAT", "text"), "log format: text or json (env LOG_FORMAT)")
+	flag.IntVar(&cfg.LogEvery, "log-every", env.int("LOG_EVERY", cfg.LogEvery), "log a progress summary every N rows at info level, 0 disables (env LOG_EVERY)")
+	flag.BoolVar(&cfg.Pretty, "pretty", env.bool("PRETTY", false), "print every rejected action with its document and error as indented JSON to stderr, for debugging mappings (env PRETTY)")
+	flag.BoolVar(&cfg.NoProgress, "no-progress", env.bool("NO_PROGRESS", false), "disable the progress bar (env NO_PROGRESS)")
+	flag.StringVar(&cfg.TypesSeparator, "types-sep", envString("TYPES_SEP", cfg.TypesSeparator), "separator between the values of the types column (env TYPES_SEP)")
+	flag.BoolVar(&cfg.ValidatePlusCode, "validate-pluscode", env.bool("VALIDATE_PLUSCODE", false), "reject rows whose plusCode is not a valid Open Location Code (env VALIDATE_PLUSCODE)")
+	flag.StringVar(&cfg.GeoType, "geo-type", envString("GEO_TYPE", cfg.GeoType), "latlng output: point for a geo_point, shape for a GeoJSON point or wkt for the raw WKT, both geo_shape (env GEO_TYPE)")
+	flag.StringVar(&cfg.LatLngFormat, "latlng-format", envString("LATLNG_FORMAT", cfg.LatLngFormat), "format of the latlng values: wkt for POINT(...), latlon for \"lat,lon\" strings or geojson for GeoJSON Point objects (env LATLNG_FORMAT)")
+	flag.StringVar(&cfg.CoordOrder, "coord-order", envString("COORD_ORDER", cfg.CoordOrder), "coordinate order inside POINT(...): lonlat (standard WKT) or latlon (env COORD_ORDER)")
+	flag.Var(&transforms, "transform", "field=transform,... applied in order to a field before indexing: trim, lower, upper, title or collapse-spaces; repeatable (env TRANSFORMS, separated by ;)")
+	flag.Var(&lookups, "lookup", "field[>target]=table mapping the values of a field, in place or into a target field; table is value:mapped,... or @file.csv with value and mapped columns; repeatable (env LOOKUPS, separated by ;)")
+	flag.StringVar(&cfg.LookupUnmapped, "lookup-unmapped", envString("LOOKUP_UNMAPPED", cfg.LookupUnmapped), "what to do with values missing from a -lookup table: pass them through, drop the field or fail the row (env LOOKUP_UNMAPPED)")
+	flag.Var(&constants, "constant-fields", "field=value added to every document, such as source=csv-seed to tag the data; repeatable (env CONSTANT_FIELDS, separated by ;)")
+	flag.Var(&filters, "filter", "only import rows where a field or CSV column equals a value, field=value or field=in:a,b; repeatable, all must match (env FILTERS, separated by ;)")
+	flag.StringVar(&cfg.DetectDuplicateIDs, "detect-duplicate-ids", os.Getenv("DETECT_DUPLICATE_IDS"), "report rows repeating an _id seen earlier in the same file: exact keeps every _id in memory, about 100 bytes per row, bloom about 1.2 bytes per row but misreports about 1% of the rows (env DETECT_DUPLICATE_IDS)")
+	flag.BoolVar(&cfg.FailOnDuplicateIDs, "fail-on-duplicate-ids", env.bool("FAIL_ON_DUPLICATE_IDS", false), "fail the run when -detect-duplicate-ids found duplicates (env FAIL_ON_DUPLICATE_IDS)")
+	flag.BoolVar(&cfg.DedupBatch, "dedup-batch", env.bool("DEDUP_BATCH", false), "send only the last row for each _id among the rows buffered for a batch (env DEDUP_BATCH)")
+	flag.StringVar(&cfg.OpType, "op-type", envString("OP_TYPE", cfg.OpType), "bulk action for new documents: index replaces existing documents, create fails them as conflicts (env OP_TYPE)")
+	flag.BoolVar(&cfg.Upsert, "upsert", env.bool("UPSERT", false), "merge into existing documents with update actions instead of replacing them (env UPSERT)")
+	flag.BoolVar(&cfg.DetectNoop, "detect-noop", env.bool("DETECT_NOOP", cfg.DetectNoop), "with -upsert, leave documents the update would not change untouched, counted as unchanged, instead of rewriting them with a new version (env DETECT_NOOP)")
+	flag.BoolVar(&cfg.Delete, "delete", env.bool("DELETE", false), "delete the documents whose IDs are listed in the CSV instead of indexing (env DELETE)")
+	flag.StringVar(&cfg.Refresh, "refresh", envString("REFRESH", cfg.Refresh), "refresh mode for each bulk request: false, true or wait_for; refreshing per batch slows large imports considerably (env REFRESH)")
+	flag.StringVar(&cfg.WaitForActiveShards, "wait-for-active-shards", os.Getenv("WAIT_FOR_ACTIVE_SHARDS"), "shard copies that must be active before each bulk write proceeds, a number or all; higher values guard against writing to too few copies during node restarts but stall or fail batches while copies are missing (env WAIT_FOR_ACTIVE_SHARDS)")
+	flag.BoolVar(&cfg.RefreshAfter, "refresh-after", env.bool("REFRESH_AFTER", false), "refresh the index once after the import so it is searchable immediately (env REFRESH_AFTER)")
+	flag.IntVar(&cfg.Limit, "limit", env.int("LIMIT", 0), "stop after this many new rows were indexed, rows rejected by Elasticsearch being replaced by the following rows while reading, 0 imports everything (env LIMIT)")
+	flag.StringVar(&cfg.PromoteAlias, "promote-alias", os.Getenv("PROMOTE_ALIAS"), "after a successful import, atomically move this alias from its current indices to the loaded index (env PROMOTE_ALIAS)")
+	flag.BoolVar(&cfg.DeleteOldIndex, "delete-old-index", env.bool("DELETE_OLD_INDEX", false), "delete the indices -promote-alias was moved away from (env DELETE_OLD_INDEX)")
+	flag.BoolVar(&cfg.Verify, "verify", env.bool("VERIFY", false), "count the documents in the index after the import and compare with the created and deleted documents (env VERIFY)")
+	flag.StringVar(&cfg.Report, "report", os.Getenv("REPORT_FILE"), "file to write a JSON report of the run to, with the counts, last IDs and failed IDs (env REPORT_FILE)")
+	flag.StringVar(&cfg.MetricsAddr, "metrics-addr", os.Getenv("METRICS_ADDR"), "address such as :9090 to serve Prometheus metrics of the import on /metrics (env METRICS_ADDR)")
+	flag.BoolVar(&cfg.DryRun, "dry-run", false, "parse and validate the CSV without connecting to Elasticsearch")
+	flag.BoolVar(&cfg.Check, "check", false, "check that Elasticsearch is reachable with the credentials, the index exists or can be created and the CSV files open with the expected header, without importing anything")
+	flag.IntVar(&cfg.ExplainBatch, "explain-batch", 0, "print the first N documents with their bulk action as they would be sent, without connecting to Elasticsearch")
+	flag.BoolVar(&cfg.Inspect, "inspect", false, "print the columns of the CSV files with a sample value and a guessed type, and suggest a -columns mapping, without connecting to Elasticsearch")
+	flag.BoolVar(&cfg.CountOnly, "count-only", false, "print the number of rows of the CSV files without parsing them or connecting to Elasticsearch")
+	flag.Parse()
+	if err := errors.Join(env.errs...); err != nil {
+		return Config{}, err
+	}
+	var configured map[string]bool
+	if *configFile != "" {
+		var err error
+		if configured, err = applyConfigFile(*configFile); err != nil {
+			return Config{}, fmt.Errorf("error loading -config: %w", err)
+		}
+	}
+	if *profile != "" {
+		if err := applyProfile(*profile, configured); err != nil {
+			return Config{}, fmt.Errorf("invalid -profile: %w", err)
+		}
+	}
+	csvFiles = append(csvFiles, flag.Args()...)
+	cfg.IDFields = splitList(*ids)
+	cfg.MirrorURLs = splitList(*mirrors)
+	if *nulls != "" {
+		for _, value := range strings.Split(*nulls, ",") {
+			cfg.NullValues = append(cfg.NullValues, strings.TrimSpace(value))
+		}
+	}
+	cfg.BoolFields = splitList(*bools)
+	cfg.DateFields = splitList(*dates)
+	cfg.ExpectedHeader = splitList(*expected)
+	cfg.Filters = filters
+	if len(cfg.Filters) == 0 && os.Getenv("FILTERS") != "" {
+		cfg.Filters = strings.Split(os.Getenv("FILTERS"), ";")
+	}
+	cfg.FieldsInclude = splitList(*include)
+	cfg.FieldsExclude = splitList(*exclude)
+	if len(csvFiles) == 0 && os.Getenv("CSV_FILE") != "" {
+		csvFiles = append(csvFiles, os.Getenv("CSV_FILE"))
+	}
+
+	var missing []string
+	if cfg.ESURL == "" && cfg.CloudID == "" && !cfg.DryRun && !cfg.CountOnly && cfg.ExplainBatch == 0 && !cfg.Inspect && (!cfg.ResetTracker || cfg.TrackerBackend == "es") {
+		missing = append(missing, "-es-url or -es-cloud-id")
+	}
+	if cfg.Index == "" && !cfg.DryRun && !cfg.CountOnly && !cfg.ResetTracker && cfg.ExplainBatch == 0 && !cfg.Inspect {
+		missing = append(missing, "-es-index")
+	}
+	if len(csvFiles) == 0 {
+		missing = append(missing, "-csv")
+	}
+	if len(missing) > 0 {
+		return Config{}, fmt.Errorf("missing required configuration: %s", strings.Join(missing, ", "))
+	}
+	files, err := expandGlobs(csvFiles)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid -csv: %w", err)
+	}
+	cfg.CSVFiles = files
+	if slices.Contains(cfg.CSVFiles, stdinFile) && len(cfg.CSVFiles) > 1 {
+		return Config{}, errors.New("stdin (-csv -) cannot be combined with other CSV files")
+	}
+	if cfg.TrackerFile != "" && len(cfg.CSVFiles) > 1 {
+		return Config{}, errors.New("-tracker-file can only be used with a single CSV file")
+	}
+	if *quiet && *verbose {
+		return Config{}, errors.New("-quiet and -verbose are mutually exclusive")
+	}
+	if *quiet {
+		*level = "error"
+		cfg.NoProgress = true
+	} else if *verbose {
+		*level = "debug"
+	}
+	if err := setupLogging(*level, *logFormat); err != nil {
+		return Config{}, err
+	}
+	if *since != "" {
+		if cfg.Since, err = time.Parse(time.RFC3339, *since); err != nil {
+			return Config{}, fmt.Errorf("invalid -since: %w", err)
+		}
+	}
+	if cfg.Delimiter, err = parseDelimiter(*delimiter); err != nil {
+		return Config{}, fmt.Errorf("invalid -delimiter: %w", err)
+	}
+	if err := parseColumnMapping(cfg.Columns, *columns); err != nil {
+		return Config{}, fmt.Errorf("invalid -columns: %w", err)
+	}
+	if cfg.FieldTypes, err = parseFieldTypes(*types); err != nil {
+		return Config{}, fmt.Errorf("invalid -field-types: %w", err)
+	}
+	if cfg.FieldNames, err = parseFieldNames(*names); err != nil {
+		return Config{}, fmt.Errorf("invalid -field-names: %w", err)
+	}
+	if len(transforms) == 0 && os.Getenv("TRANSFORMS") != "" {
+		transforms = strings.Split(os.Getenv("TRANSFORMS"), ";")
+	}
+	if cfg.Transforms, err = parseTransforms(transforms); err != nil {
+		return Config{}, fmt.Errorf("invalid -transform: %w", err)
+	}
+	if len(constants) == 0 && os.Getenv("CONSTANT_FIELDS") != "" {
+		constants = strings.Split(os.Getenv("CONSTANT_FIELDS"), ";")
+	}
+	if cfg.ConstantFields, err = parseConstantFields(constants); err != nil {
+		return Config{}, fmt.Errorf("invalid -constant-fields: %w", err)
+	}
+	if len(lookups) == 0 && os.Getenv("LOOKUPS") != "" {
+		lookups = strings.Split(os.Getenv("LOOKUPS"), ";")
+	}
+	if cfg.Lookups, err = parseLookups(lookups); err != nil {
+		return Config{}, fmt.Errorf("invalid -lookup: %w", err)
+	}
+	return cfg, cfg.Validate()
+}
+
+// Checks the settings are consistent
+func (cfg Config) Validate() error {
+	if cfg.ESURL != "" && cfg.CloudID != "" {
+		return errors.New("only one of -es-url and -es-cloud-id may be set")
+	}
+	if cfg.FlushBytes <= 0 {
+		return fmt.Errorf("-flush-bytes must be greater than zero, got %d", cfg.FlushBytes)
+	}
+	if cfg.BulkSize < 0 {
+		return fmt.Errorf("-bulk-size must not be negative, got %d", cfg.BulkSize)
+	}
+	if cfg.FlushBytes > cfg.MaxRequestBytes {
+		return fmt.Errorf("-flush-bytes must not exceed -max-request-bytes, got %d > %d", cfg.FlushBytes, cfg.MaxRequestBytes)
+	}
+	if cfg.FlushInterval <= 0 {
+		return fmt.Errorf("-flush-interval must be greater than zero, got %s", cfg.FlushInterval)
+	}
+	if cfg.Workers <= 0 {
+		return fmt.Errorf("-workers must be greater than zero, got %d", cfg.Workers)
+	}
+	if cfg.MaxRetries < 0 {
+		return fmt.Errorf("-max-retries must not be negative, got %d", cfg.MaxRetries)
+	}
+	if cfg.WaitForCluster < 0 {
+		return fmt.Errorf("-wait-for-cluster must not be negative, got %s", cfg.WaitForCluster)
+	}
+	if cfg.MaxIdleConnsPerHost < 0 {
+		return fmt.Errorf("-max-idle-conns-per-host must not be negative, got %d", cfg.MaxIdleConnsPerHost)
+	}
+	if cfg.IdleConnTimeout < 0 {
+		return fmt.Errorf("-idle-conn-timeout must not be negative, got %s", cfg.IdleConnTimeout)
+	}
+	if cfg.RequestTimeout < 0 {
+		return fmt.Errorf("-request-timeout must not be negative, got %s", cfg.RequestTimeout)
+	}
+	if cfg.TypesSeparator == "" {
+		return errors.New("-types-sep must not be empty")
+	}
+	if cfg.Limit < 0 {
+		return fmt.Errorf("-limit must not be negative, got %d", cfg.Limit)
+	}
+	if cfg.Delete && cfg.Upsert {
+		return errors.New("-delete and -upsert cannot be combined")
+	}
+	for _, field := range cfg.DateFields {
+		if slices.Contains(cfg.BoolFields, field) {
+			return fmt.Errorf("%s cannot be both a boolean and a date field", field)
+		}
+		if _, typed := cfg.FieldTypes[field]; typed {
+			return fmt.Errorf("%s cannot be both a date field and in -field-types", field)
+		}
+		switch field {
+		case "id", "latlng", "types":
+			return fmt.Errorf("%s cannot be a date field", field)
+		}
+	}
+	for _, spec := range cfg.Filters {
+		if _, err := parseFilter(spec); err != nil {
+			return fmt.Errorf("invalid -filter: %w", err)
+		}
+	}
+	if len(cfg.FieldsInclude) > 0 && len(cfg.FieldsExclude) > 0 {
+		return errors.New("-fields-include and -fields-exclude cannot be combined")
+	}
+	for _, field := range append(cfg.FieldsInclude, cfg.FieldsExclude...) {
+		if _, known := defaultColumnNames[field]; !known && !slices.Contains(cfg.BoolFields, field) && !slices.Contains(cfg.DateFields, field) {
+			return fmt.Errorf("unknown field %q in -fields-include or -fields-exclude", field)
+		}
+	}
+	// Transforms apply to the column of a field, not to a renamed document field
+	for field := range cfg.Transforms {
+		if field == "id" || field == "latlng" {
+			return fmt.Errorf("-transform: %s cannot be transformed", field)
+		}
+		if _, known := defaultColumnNames[field]; !known && !slices.Contains(cfg.BoolFields, field) && !slices.Contains(cfg.DateFields, field) {
+			return fmt.Errorf("unknown field %q in -transform", field)
+		}
+	}
+	for _, field := range indexFields(cfg.Index) {
+		if _, known := defaultColumnNames[field]; !known || field == "id" {
+			return fmt.Errorf("unknown field %q in -es-index", field)
+		}
+		if cfg.PromoteAlias != "" {
+			return errors.New("-promote-alias cannot be combined with an -es-index template")
+		}
+	}
+	if cfg.VersionType != "external" && cfg.VersionType != "external_gte" {
+		return fmt.Errorf("-version-type must be external or external_gte, got %q", cfg.VersionType)
+	}
+	if cfg.VersionField != "" && cfg.Upsert {
+		return errors.New("-version-field cannot be combined with -upsert, updates do not support external versions")
+	}
+	if cfg.ThrottleMin < 0 || cfg.ThrottleInitial < cfg.ThrottleMin || cfg.ThrottleMax < cfg.ThrottleInitial {
+		return errors.New("throttle delays must satisfy 0 <= -throttle-min <= -throttle-initial <= -throttle-max")
+	}
+	if cfg.ItemRetries < 0 {
+		return fmt.Errorf("-item-retries must not be negative, got %d", cfg.ItemRetries)
+	}
+	if cfg.DeleteOldIndex && cfg.PromoteAlias == "" {
+		return errors.New("-delete-old-index requires -promote-alias")
+	}
+	if cfg.DetectDuplicateIDs != "" && cfg.DetectDuplicateIDs != "exact" && cfg.DetectDuplicateIDs != "bloom" {
+		return fmt.Errorf("-detect-duplicate-ids must be exact or bloom, got %q", cfg.DetectDuplicateIDs)
+	}
+	if cfg.TimestampField != "" && cfg.Since.IsZero() {
+		return errors.New("-timestamp-field requires -since")
+	}
+	if !cfg.Since.IsZero() && cfg.TimestampField == "" {
+		return errors.New("-since requires -timestamp-field")
+	}
+	if cfg.FailOnDuplicateIDs && cfg.DetectDuplicateIDs == "" {
+		return errors.New("-fail-on-duplicate-ids requires -detect-duplicate-ids")
+	}
+	if cfg.KeepRaw != "" {
+		if cfg.KeepRaw == "ingestedAt" && cfg.IngestTimestamp {
+			return errors.New("-keep-raw must not be ingestedAt with -add-ingest-timestamp")
+		}
+		if isDocumentField(cfg, cfg.KeepRaw) {
+			return fmt.Errorf("-keep-raw must not be a document field, got %q", cfg.KeepRaw)
+		}
+	}
+	if cfg.IDStrategy != "column" && cfg.IDStrategy != "hash-of-row" && cfg.IDStrategy != "auto" {
+		return fmt.Errorf("-id-strategy must be column, hash-of-row or auto, got %q", cfg.IDStrategy)
+	}
+	if cfg.IDStrategy != "column" && cfg.IDEncoding != "raw" {
+		return errors.New("-id-encoding only applies to -id-strategy column")
+	}
+	if cfg.IDStrategy == "auto" {
+		switch {
+		case len(cfg.IDFields) > 0:
+			return errors.New("-id-fields cannot be used with -id-strategy auto")
+		case cfg.Delete || cfg.Upsert || cfg.VersionField != "":
+			return errors.New("-delete, -upsert and -version-field need an _id and cannot be used with -id-strategy auto")
+		case cfg.DedupBatch || cfg.DetectDuplicateIDs != "":
+			return errors.New("-dedup-batch and -detect-duplicate-ids compare _id values and cannot be used with -id-strategy auto")
+		}
+	}
+	if cfg.IDEncoding != "raw" && cfg.IDEncoding != "urlsafe" && cfg.IDEncoding != "sha1" {
+		return fmt.Errorf("-id-encoding must be raw, urlsafe or sha1, got %q", cfg.IDEncoding)
+	}
+	if cfg.IDEncoding != "raw" {
+		if cfg.IDOriginalField == "" || isDocumentField(cfg, cfg.IDOriginalField) || cfg.IDOriginalField == cfg.KeepRaw || (cfg.IDOriginalField == "ingestedAt" && cfg.IngestTimestamp) {
+			return fmt.Errorf("-id-original-field must name a field of its own, got %q", cfg.IDOriginalField)
+		}
+		if _, constant := cfg.ConstantFields[cfg.IDOriginalField]; constant {
+			return fmt.Errorf("-id-original-field must name a field of its own, got %q", cfg.IDOriginalField)
+		}
+	}
+	for _, lookup := range cfg.Lookups {
+		if lookup.target != lookup.field && isDocumentField(cfg, lookup.target) {
+			return fmt.Errorf("-lookup target must not be a document field, got %q", lookup.target)
+		}
+	}
+	for field := range cfg.ConstantFields {
+		if isDocumentField(cfg, field) || field == cfg.KeepRaw || (field == "ingestedAt" && cfg.IngestTimestamp) {
+			return fmt.Errorf("-constant-fields must not set a document field, got %q", field)
+		}
+	}
+	if cfg.ExplainBatch < 0 {
+		return fmt.Errorf("-explain-batch must not be negative, got %d", cfg.ExplainBatch)
+	}
+	if cfg.LookupUnmapped != "pass" && cfg.LookupUnmapped != "drop" && cfg.LookupUnmapped != "fail" {
+		return fmt.Errorf("-lookup-unmapped must be pass, drop or fail, got %q", cfg.LookupUnmapped)
+	}
+	if cfg.InputFormat != "csv" && cfg.InputFormat != "ndjson" {
+		return fmt.Errorf("-input-format must be csv or ndjson, got %q", cfg.InputFormat)
+	}
+	if cfg.InputFormat == "ndjson" && cfg.LazyQuotes {
+		return errors.New("-lazy-quotes only applies to -input-format csv")
+	}
+	if cfg.TrackerBackend != "file" && cfg.TrackerBackend != "es" {
+		return fmt.Errorf("-tracker-backend must be file or es, got %q", cfg.TrackerBackend)
+	}
+	if cfg.TrackerBackend == "es" && cfg.TrackerFile != "" {
+		return errors.New("-tracker-file only applies to -tracker-backend file")
+	}
+	if cfg.TrackerBackend == "es" && cfg.TrackerIndex == "" {
+		return errors.New("-tracker-backend es requires -tracker-index")
+	}
+	if cfg.OpType != "index" && cfg.OpType != "create" {
+		return fmt.Errorf("-op-type must be index or create, got %q", cfg.OpType)
+	}
+	if cfg.OpType == "create" && (cfg.Upsert || cfg.Delete) {
+		return errors.New("-op-type create cannot be combined with -upsert or -delete")
+	}
+	if cfg.Refresh != "false" && cfg.Refresh != "true" && cfg.Refresh != "wait_for" {
+		return fmt.Errorf("-refresh must be false, true or wait_for, got %q", cfg.Refresh)
+	}
+	if cfg.WaitForActiveShards != "" && cfg.WaitForActiveShards != "all" {
+		if n, err := strconv.Atoi(cfg.WaitForActiveShards); err != nil || n < 1 {
+			return fmt.Errorf("-wait-for-active-shards must be a positive number or all, got %q", cfg.WaitForActiveShards)
+		}
+	}
+	if cfg.GeoType != "point" && cfg.GeoType != "shape" && cfg.GeoType != "wkt" {
+		return fmt.Errorf("-geo-type must be point, shape or wkt, got %q", cfg.GeoType)
+	}
+	if cfg.LatLngFormat != "wkt" && cfg.LatLngFormat != "latlon" && cfg.LatLngFormat != "geojson" {
+		return fmt.Errorf("-latlng-format must be wkt, latlon or geojson, got %q", cfg.LatLngFormat)
+	}
+	if cfg.GeoType == "wkt" && cfg.LatLngFormat != "wkt" {
+		return errors.New("-geo-type wkt indexes the values as is and requires -latlng-format wkt")
+	}
+	if cfg.InputFormat == "ndjson" && cfg.LatLngFormat == "geojson" {
+		return errors.New("GeoJSON points of NDJSON records are read as WKT, use -latlng-format wkt")
+	}
+	if cfg.CoordOrder != "lonlat" && cfg.CoordOrder != "latlon" {
+		return fmt.Errorf("-coord-order must be lonlat or latlon, got %q", cfg.CoordOrder)
+	}
+	return nil
+}
+
+// Sets the minimum level and the format of the default logger
+func setupLogging(levelName, format string) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(levelName)); err != nil {
+		return fmt.Errorf("invalid -log-level: expected error, warn, info or debug, got %q", levelName)
+	}
+	switch format {
+	case "text":
+		slog.SetLogLoggerLevel(level)
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	default:
+		return fmt.Errorf("invalid -log-format: expected text or json, got %q", format)
+	}
+	return nil
+}
+
+// Parses the CSV delimiter from a single character or the \t escape
+func parseDelimiter(value string) (rune, error) {
+	if value == `\t` {
+		value = "\t"
+	}
+	runes := []rune(value)
+	if len(runes) != 1 {
+		return 0, fmt.Errorf("expected a single character, got %q", value)
+	}
+	if runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' {
+		return 0, fmt.Errorf("%q cannot be used as a delimiter", value)
+	}
+	return runes[0], nil
+}
+
+// Flag value collecting every occurrence of a repeated flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// Splits a comma-separated list, dropping empty elements
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Expands glob patterns in the input paths, keeping plain paths as they are
+func expandGlobs(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		if !strings.ContainsAny(path, "*?[") {
+			files = append(files, path)
+			continue
+		}
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %w", path, err)
+		}
+		// Trackers live next to the CSV files and share their extension
+		matches = slices.DeleteFunc(matches, func(m string) bool {
+			return strings.HasSuffix(m, trackerSuffix) || strings.HasSuffix(m, legacyTrackerSuffix)
+		})
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("pattern %q matched no files", path)
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// Applies field=header overrides to the column mapping
+func parseColumnMapping(columnNames map[string]string, spec string) error {
+	if spec == "" {
+		return nil
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		field, header, ok := strings.Cut(pair, "=")
+		field = strings.TrimSpace(field)
+		header = strings.TrimSpace(header)
+		if !ok || field == "" || header == "" {
+			return fmt.Errorf("expected field=header, got %q", pair)
+		}
+		if _, known := columnNames[field]; !known {
+			return fmt.Errorf("unknown field %q", field)
+		}
+		columnNames[field] = header
+	}
+	return nil
+}
+
+// Parses field=name renames of the document fields
+func parseFieldNames(spec string) (map[string]string, error) {
+	fieldNames := make(map[string]string)
+	targets := make(map[string]string)
+	for _, pair := range splitList(spec) {
+		field, name, ok := strings.Cut(pair, "=")
+		field = strings.TrimSpace(field)
+		name = strings.TrimSpace(name)
+		if !ok || field == "" || name == "" {
+			return nil, fmt.Errorf("expected field=name, got %q", pair)
+		}
+		if _, known := defaultColumnNames[field]; !known || field == "id" {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		if other, taken := targets[name]; taken {
+			return nil, fmt.Errorf("%s and %s are both renamed to %s", other, field, name)
+		}
+		targets[name] = field
+		fieldNames[field] = name
+	}
+	return fieldNames, nil
+}
+
+// Reports whether a name is taken by a document field, after the renames
+func isDocumentField(cfg Config, name string) bool {
+	for field := range defaultColumnNames {
+		documentName, renamed := cfg.FieldNames[field]
+		if !renamed {
+			documentName = field
+		}
+		if field != "id" && documentName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses the field=value pairs added to every document
+func parseConstantFields(specs []string) (map[string]string, error) {
+	constants := make(map[string]string)
+	for _, spec := range specs {
+		field, value, ok := strings.Cut(spec, "=")
+		field = strings.TrimSpace(field)
+		if !ok || field == "" {
+			return nil, fmt.Errorf("expected field=value, got %q", spec)
+		}
+		if _, taken := constants[field]; taken {
+			return nil, fmt.Errorf("%s is set twice", field)
+		}
+		constants[field] = value
+	}
+	return constants, nil
+}
+
+// Parses field=transform,... specs into the transforms of every field
+func parseTransforms(specs []string) (map[string][]string, error) {
+	transforms := make(map[string][]string)
+	for _, spec := range specs {
+		field, list, ok := strings.Cut(spec, "=")
+		field = strings.TrimSpace(field)
+		if !ok || field == "" {
+			return nil, fmt.Errorf("expected field=transform,..., got %q", spec)
+		}
+		if field == "id" || field == "latlng" {
+			return nil, fmt.Errorf("%s cannot be transformed", field)
+		}
+		for _, name := range splitList(list) {
+			if _, known := stringTransforms[name]; !known {
+				return nil, fmt.Errorf("unknown transform %q for %s, expected trim, lower, upper, title or collapse-spaces", name, field)
+			}
+			transforms[field] = append(transforms[field], name)
+		}
+	}
+	return transforms, nil
+}
+
+// Parses field=type conversions for the plain string fields
+func parseFieldTypes(spec string) (map[string]string, error) {
+	fieldTypes := make(map[string]string)
+	for _, pair := range splitList(spec) {
+		field, typ, ok := strings.Cut(pair, "=")
+		field = strings.TrimSpace(field)
+		typ = strings.TrimSpace(typ)
+		if !ok || field == "" {
+			return nil, fmt.Errorf("expected field=type, got %q", pair)
+		}
+		switch field {
+		case "id", "latlng", "types":
+			return nil, fmt.Errorf("the type of %s cannot be changed", field)
+		}
+		if _, known := defaultColumnNames[field]; !known {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		switch typ {
+		case "string", "int", "float", "bool":
+		default:
+			return nil, fmt.Errorf("unknown type %q for %s, expected string, int, float or bool", typ, field)
+		}
+		fieldTypes[field] = typ
+	}
+	return fieldTypes, nil
+}
+
+// Row predicate of -filter, matching when the field equals one of the values
+type rowFilter struct {
+	field  string
+	values []string
+}
+
+// Parses a field=value or field=in:a,b filter
+func parseFilter(spec string) (rowFilter, error) {
+	field, value, ok := strings.Cut(spec, "=")
+	field = strings.TrimSpace(field)
+	if !ok || field == "" {
+		return rowFilter{}, fmt.Errorf("expected field=value or field=in:a,b, got %q", spec)
+	}
+	values := []string{strings.TrimSpace(value)}
+	if list, ok := strings.CutPrefix(value, "in:"); ok {
+		values = splitList(list)
+		if len(values) == 0 {
+			return rowFilter{}, fmt.Errorf("empty in: list for %s", field)
+		}
+	}
+	return rowFilter{field: field, values: values}, nil
+}
+
+// Returns the position of every column in the CSV header by name
+func headerPositions(header []string) map[string]int {
+	positions := make(map[string]int, len(header))
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		positions[strings.TrimSpace(name)] = i
+	}
+	return positions
+}
+
+// Compares the CSV header with the expected column names, listing the missing
+// and unexpected columns
+func checkHeader(positions map[string]int, expected []string) error {
+	if len(expected) == 0 {
+		return nil
+	}
+	var missing, unexpected []string
+	for _, name := range expected {
+		if _, ok := positions[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	for name := range positions {
+		if !slices.Contains(expected, name) {
+			unexpected = append(unexpected, name)
+		}
+	}
+	if len(missing) == 0 && len(unexpected) == 0 {
+		return nil
+	}
+	sort.Strings(unexpected)
+	var diff []string
+	if len(missing) > 0 {
+		diff = append(diff, "missing "+strings.Join(missing, ", "))
+	}
+	if len(unexpected) > 0 {
+		diff = append(diff, "unexpected "+strings.Join(unexpected, ", "))
+	}
+	return errors.New(strings.Join(diff, "; "))
+}
+
+// Resolves the positions of the columns composing the document _id
+func mapIDColumns(positions map[string]int, cfg Config) ([]int, error) {
+	var mapped []int
+	var missing []string
+	for _, name := range cfg.IDFields {
+		i, ok := positions[name]
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		mapped = append(mapped, i)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing id columns in CSV header: %s", strings.Join(missing, ", "))
+	}
+	return mapped, nil
+}
+
+// Resolves the positions of the boolean fields, either mapped fields or
+// extra columns indexed under their header name
+func mapBoolColumns(positions map[string]int, cols map[string]int, cfg Config) (map[string]int, error) {
+	return mapFieldColumns(positions, cols, cfg.BoolFields, "boolean", cfg)
+}
+
+// Resolves the positions of the date fields, like the boolean fields
+func mapDateColumns(positions map[string]int, cols map[string]int, cfg Config) (map[string]int, error) {
+	return mapFieldColumns(positions, cols, cfg.DateFields, "date", cfg)
+}
+
+// Resolves the positions of fields converted to a type, looking them up in
+// the mapped fields first and in the CSV header otherwise
+func mapFieldColumns(positions map[string]int, cols map[string]int, fields []string, kind string, cfg Config) (map[string]int, error) {
+	mapped := make(map[string]int, len(fields))
+	if cfg.Delete {
+		return mapped, nil
+	}
+	var missing []string
+	for _, field := range fields {
+		if i, ok := cols[field]; ok {
+			mapped[field] = i
+		} else if i, ok := positions[field]; ok {
+			mapped[field] = i
+		} else {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing %s columns in CSV header: %s", kind, strings.Join(missing, ", "))
+	}
+	return mapped, nil
+}
+
+// Resolves the position of every mapped column in the CSV header
+func mapColumns(positions map[string]int, cfg Config) (map[string]int, error) {
+	mapped := make(map[string]int, len(cfg.Columns))
+	var missing []string
+	for field, name := range cfg.Columns {
+		// The id column is not needed when the _id is composed from other columns
+		if field == "id" && (len(cfg.IDFields) > 0 || cfg.IDStrategy != "column") {
+			continue
+		}
+		// Deletes only need the columns forming the _id and the index name
+		if field != "id" && cfg.Delete && !slices.Contains(indexFields(cfg.Index), field) {
+			continue
+		}
+		i, ok := positions[name]
+		if !ok {
+			missing = append(missing, fmt.Sprintf("%s (for %s)", name, field))
+			continue
+		}
+		mapped[field] = i
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("missing columns in CSV header: %s", strings.Join(missing, ", "))
+	}
+	return mapped, nil
+}
+
+// Returns the value of an environment variable or the default
+func envString(key string, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+// Parses typed environment variables, collecting the values that do not parse
+type envReader struct {
+	errs []error
+}
+
+// Returns the integer value of an environment variable or the default
+func (e *envReader) int(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("error parsing %s: %w", key, err))
+		return def
+	}
+	return n
+}
+
+// Returns the boolean value of an environment variable or the default
+func (e *envReader) bool(key string, def bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("error parsing %s: %w", key, err))
+		return def
+	}
+	return b
+}
+
+// Returns the duration value of an environment variable or the default
+func (e *envReader) duration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("error parsing %s: %w", key, err))
+		return def
+	}
+	return d
+}
+
+// Import counters at a point in time
+type counts struct {
+	imported, skipped, failed, deleted, notFound, filtered, conflicts, stale, duplicates, noops int64
+}
+
+func (c counts) sub(o counts) counts {
+	return counts{
+		imported:   c.imported - o.imported,
+		skipped:    c.skipped - o.skipped,
+		failed:     c.failed - o.failed,
+		deleted:    c.deleted - o.deleted,
+		notFound:   c.notFound - o.notFound,
+		filtered:   c.filtered - o.filtered,
+		conflicts:  c.conflicts - o.conflicts,
+		stale:      c.stale - o.stale,
+		duplicates: c.duplicates - o.duplicates,
+		noops:      c.noops - o.noops,
+	}
+}
+
+// Formats the counts relevant to the import mode
+func (c counts) format(deleteMode bool) string {
+	var s string
+	if deleteMode {
+		s = fmt.Sprintf("deleted: %d, not found: %d, skipped: %d, failed: %d", c.deleted, c.notFound, c.skipped, c.failed)
+	} else {
+		s = fmt.Sprintf("imported: %d, skipped: %d, failed: %d", c.imported, c.skipped, c.failed)
+	}
+	if c.noops > 0 {
+		s += fmt.Sprintf(", unchanged: %d", c.noops)
+	}
+	if c.conflicts > 0 {
+		s += fmt.Sprintf(", of which conflicts: %d", c.conflicts)
+	}
+	if c.filtered > 0 {
+		s += fmt.Sprintf(", filtered: %d", c.filtered)
+	}
+	if c.stale > 0 {
+		s += fmt.Sprintf(", stale versions: %d", c.stale)
+	}
+	if c.duplicates > 0 {
+		s += fmt.Sprintf(", duplicate IDs: %d", c.duplicates)
+	}
+	return s
+}
+
+// Outcome of importing a single file
+type fileResult struct {
+	file       string
+	counts     counts
+	lastID     string
+	errorLines []int
+}
+
+// Prints the per-file counts followed by the totals
+func printSummary(results []fileResult, total counts, deleteMode bool) {
+	if len(results) > 1 {
+		for _, r := range results {
+			fmt.Printf("%s: %s\n", r.file, r.counts.format(deleteMode))
+		}
+	}
+	fmt.Printf("Total: %s\n", total.format(deleteMode))
+}
+
+// Prints what an import would have done
+func printDryRunReport(results []fileResult, total counts) {
+	fmt.Println("Dry run complete, nothing was indexed.")
+	for _, r := range results {
+		if len(results) > 1 {
+			fmt.Printf("%s: would import: %d, would skip: %d\n", r.file, r.counts.imported, r.counts.skipped)
+		}
+		printErrorLines(r.errorLines)
+	}
+	fmt.Printf("Would import: %d, would skip: %d\n", total.imported, total.skipped)
+	if total.filtered > 0 {
+		fmt.Printf("Filtered out: %d\n", total.filtered)
+	}
+}
+
+// Prints the line numbers of rows that failed to parse
+func printErrorLines(errorLines []int) {
+	if len(errorLines) == 0 {
+		return
+	}
+
+	const maxLines = 100
+	lines := make([]string, 0, maxLines)
+	for i, line := range errorLines {
+		if i == maxLines {
+			break
+		}
+		lines = append(lines, strconv.Itoa(line))
+	}
+	fmt.Printf("Parse errors on lines: %s", strings.Join(lines, ", "))
+	if len(errorLines) > maxLines {
+		fmt.Printf(" and %d more", len(errorLines)-maxLines)
+	}
+	fmt.Println()
+}
@@ -0,0 +1,128 @@
+package eslocationseed
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Matches the environment variable named in a flag usage
+var envUsage = regexp.MustCompile(`\(env ([A-Z_]+)`)
+
+// Flag values of each -profile
+var profiles = map[string]map[string]string{
+	"fast": {
+		"refresh":     "false",
+		"flush-bytes": "20971520",
+		"workers":     "4",
+	},
+	"safe": {
+		"refresh":                "wait_for",
+		"flush-bytes":            "1048576",
+		"workers":                "1",
+		"wait-for-active-shards": "all",
+	},
+}
+
+// Applies the flag values of a profile to the flags set neither on the
+// command line, nor through their environment variable, nor in the config file
+func applyProfile(name string, configured map[string]bool) error {
+	settings, ok := profiles[name]
+	if !ok {
+		return fmt.Errorf("expected fast or safe, got %q", name)
+	}
+	set := setFlags()
+	for flagName, value := range settings {
+		f := flag.Lookup(flagName)
+		if set[flagName] || configured[flagName] || envSet(f) {
+			continue
+		}
+		if err := f.Value.Set(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Applies the settings of a YAML or JSON config file, keyed by flag name, to
+// the flags set neither on the command line nor through their environment
+// variable, returning the names of the flags it set
+func applyConfigFile(path string) (map[string]bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+
+	set := setFlags()
+	configured := make(map[string]bool)
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := flag.Lookup(name)
+		if f == nil || name == "config" {
+			return nil, fmt.Errorf("unknown setting %q in %s", name, path)
+		}
+		if set[name] || envSet(f) {
+			continue
+		}
+		for _, value := range settingValues(settings[name], f) {
+			if err := f.Value.Set(value); err != nil {
+				return nil, fmt.Errorf("invalid %s in %s: %w", name, path, err)
+			}
+		}
+		configured[name] = true
+	}
+	return configured, nil
+}
+
+// Returns the names of the flags set on the command line
+func setFlags() map[string]bool {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	return set
+}
+
+// Returns whether a flag is set through the environment variable named in its usage
+func envSet(f *flag.Flag) bool {
+	match := envUsage.FindStringSubmatch(f.Usage)
+	return match != nil && os.Getenv(match[1]) != ""
+}
+
+// Converts a setting to flag values: lists are comma-separated unless the
+// flag is repeatable, and maps become comma-separated key=value pairs
+func settingValues(setting interface{}, f *flag.Flag) []string {
+	switch value := setting.(type) {
+	case []interface{}:
+		values := make([]string, len(value))
+		for i, v := range value {
+			values[i] = fmt.Sprint(v)
+		}
+		if _, repeatable := f.Value.(*stringList); repeatable {
+			return values
+		}
+		return []string{strings.Join(values, ",")}
+	case map[string]interface{}:
+		pairs := make([]string, 0, len(value))
+		for k, v := range value {
+			pairs = append(pairs, fmt.Sprintf("%s=%v", k, v))
+		}
+		sort.Strings(pairs)
+		return []string{strings.Join(pairs, ",")}
+	case nil:
+		return nil
+	}
+	return []string{fmt.Sprint(setting)}
+}
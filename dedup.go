@@ -0,0 +1,82 @@
+package eslocationseed
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+)
+
+// Passed to the failure callback of an item replaced by a later item with the
+// same _id before it was sent
+var errDuplicateCollapsed = errors.New("duplicate collapsed")
+
+// Bulk indexer buffering about a flush worth of items, so that only the last
+// item for each _id is sent
+type dedupIndexer struct {
+	esutil.BulkIndexer
+	flushBytes int
+
+	items   []esutil.BulkIndexerItem
+	dropped []bool
+	byID    map[string]int
+	size    int
+}
+
+// Wraps a bulk indexer to collapse items with the same _id within a batch
+func newDedupIndexer(bi esutil.BulkIndexer, flushBytes int) *dedupIndexer {
+	return &dedupIndexer{BulkIndexer: bi, flushBytes: flushBytes, byID: make(map[string]int)}
+}
+
+// Buffers an item, replacing the pending item with the same _id
+func (d *dedupIndexer) Add(ctx context.Context, item esutil.BulkIndexerItem) error {
+	// Rows named after their values can land the same _id in different indices
+	key := item.Index + "/" + item.DocumentID
+	if i, ok := d.byID[key]; ok && item.DocumentID != "" {
+		replaced := d.items[i]
+		d.dropped[i] = true
+		if replaced.OnFailure != nil {
+			replaced.OnFailure(ctx, replaced, esutil.BulkIndexerResponseItem{}, errDuplicateCollapsed)
+		}
+	}
+	d.byID[key] = len(d.items)
+	d.items = append(d.items, item)
+	d.dropped = append(d.dropped, false)
+	if body, ok := item.Body.(*bytes.Reader); ok {
+		d.size += body.Len()
+	} else {
+		d.size += len(item.DocumentID)
+	}
+
+	if d.size >= d.flushBytes {
+		return d.flush(ctx)
+	}
+	return nil
+}
+
+// Hands the buffered items to the bulk indexer, in the order they were added
+func (d *dedupIndexer) flush(ctx context.Context) error {
+	for i, item := range d.items {
+		if d.dropped[i] {
+			continue
+		}
+		if err := d.BulkIndexer.Add(ctx, item); err != nil {
+			return err
+		}
+	}
+	d.items = d.items[:0]
+	d.dropped = d.dropped[:0]
+	d.size = 0
+	clear(d.byID)
+	return nil
+}
+
+// Sends the buffered items and closes the bulk indexer
+func (d *dedupIndexer) Close(ctx context.Context) error {
+	err := d.flush(ctx)
+	if closeErr := d.BulkIndexer.Close(ctx); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package eslocationseed
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// Duplicate _ids listed in the summary, the others are only counted
+const maxDuplicateExamples = 10
+
+// Rows assumed for the bloom filter of stdin, whose rows cannot be counted upfront
+const defaultBloomRows = 10_000_000
+
+// False positive rate the bloom filter is sized for
+const bloomFalsePositiveRate = 0.01
+
+// Remembers the _ids seen in a file to report the rows repeating one.
+// The exact mode keeps every _id with its line, roughly 100 bytes per row or
+// 1 GB for 10 million rows. The bloom mode needs about 1.2 bytes per row but
+// cannot tell where an _id was first seen and reports about 1% of the unique
+// rows as duplicates by mistake.
+type duplicateDetector struct {
+	lines map[string]int
+	bloom *bloomFilter
+}
+
+// A duplicate _id and the lines it was seen on, the first line is 0 when unknown
+type duplicateID struct {
+	id        string
+	line      int
+	firstLine int
+}
+
+// Creates a detector for the -detect-duplicate-ids mode, sized for the number of rows
+func newDuplicateDetector(mode string, rows int) *duplicateDetector {
+	if mode == "bloom" {
+		if rows <= 0 {
+			rows = defaultBloomRows
+		}
+		return &duplicateDetector{bloom: newBloomFilter(rows, bloomFalsePositiveRate)}
+	}
+	return &duplicateDetector{lines: make(map[string]int)}
+}
+
+// Records the _id seen on a line, returning the line it was first seen on
+// and whether it was seen before
+func (d *duplicateDetector) check(id string, line int) (int, bool) {
+	if d.bloom != nil {
+		return 0, d.bloom.add(id)
+	}
+	if first, ok := d.lines[id]; ok {
+		return first, true
+	}
+	d.lines[id] = line
+	return 0, false
+}
+
+// Bloom filter over strings, using double hashing of a 64-bit FNV-1a hash
+type bloomFilter struct {
+	bits   []uint64
+	size   uint64
+	hashes uint64
+}
+
+// Sizes a bloom filter for n items at the false positive rate p
+func newBloomFilter(n int, p float64) *bloomFilter {
+	size := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	hashes := uint64(math.Max(1, math.Round(float64(size)/float64(n)*math.Ln2)))
+	return &bloomFilter{bits: make([]uint64, (size+63)/64), size: size, hashes: hashes}
+}
+
+// Adds an item, returning whether it was possibly added before
+func (b *bloomFilter) add(item string) bool {
+	h := fnv.New64a()
+	h.Write([]byte(item))
+	sum := h.Sum64()
+	h1, h2 := sum&math.MaxUint32, sum>>32|1
+
+	seen := true
+	for i := uint64(0); i < b.hashes; i++ {
+		bit := (h1 + i*h2) % b.size
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if b.bits[word]&mask == 0 {
+			seen = false
+			b.bits[word] |= mask
+		}
+	}
+	return seen
+}
@@ -0,0 +1,168 @@
+package eslocationseed
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// Returned when another run saved the tracker document since this run read it
+var errTrackerConflict = errors.New("tracker was updated by another run")
+
+// Tracker document in the -tracker-index, named after the path of the CSV file
+// relative to the working directory so the runs of every machine importing
+// that file share it, while same-named files of other directories do not. Saves only
+// succeed while the document is unchanged since this run last read or saved
+// it, so concurrent runs of the same file cannot overwrite each other.
+type esTracker struct {
+	es      *elasticsearch.Client
+	index   string
+	csvFile string
+	id      string
+
+	// Version of the document last read or saved, nil when there is none
+	seqNo       *int
+	primaryTerm *int
+}
+
+// Creates the tracker of a CSV file in the meta index
+func newESTracker(es *elasticsearch.Client, index, csvFile string) *esTracker {
+	return &esTracker{es: es, index: index, csvFile: csvFile, id: trackerDocumentID(csvFile)}
+}
+
+// Returns the slash-separated path of a CSV file relative to the working
+// directory, the absolute path for files outside of it
+func trackerDocumentID(csvFile string) string {
+	path, err := filepath.Abs(csvFile)
+	if err != nil {
+		return filepath.ToSlash(csvFile)
+	}
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			path = rel
+		}
+	}
+	return filepath.ToSlash(path)
+}
+
+// Returns the document ID escaped for the request paths, which the client
+// sends as given
+func (t *esTracker) docID() string {
+	return url.PathEscape(t.id)
+}
+
+// Tracker document as returned by a get, with its version
+type esTrackerHit struct {
+	SeqNo       int          `json:"_seq_no"`
+	PrimaryTerm int          `json:"_primary_term"`
+	Source      trackerState `json:"_source"`
+}
+
+// Reads the tracker document. Only the size of the file is compared, its
+// path and modification time differ between machines.
+func (t *esTracker) load() (trackerEntry, error) {
+	res, err := t.es.Get(t.index, t.docID())
+	if err != nil {
+		return trackerEntry{}, fmt.Errorf("error reading tracker %s: %w", t, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return trackerEntry{}, nil
+	}
+	if res.IsError() {
+		return trackerEntry{}, fmt.Errorf("error reading tracker %s: %s", t, res.String())
+	}
+	var hit esTrackerHit
+	if err := json.NewDecoder(res.Body).Decode(&hit); err != nil {
+		return trackerEntry{}, fmt.Errorf("error parsing tracker %s: %w", t, err)
+	}
+	t.seqNo, t.primaryTerm = &hit.SeqNo, &hit.PrimaryTerm
+
+	info, err := os.Stat(t.csvFile)
+	if err != nil {
+		return trackerEntry{}, err
+	}
+	if hit.Source.Size != info.Size() {
+		return trackerEntry{}, fmt.Errorf("%s changed since tracker %s was written, use -reset-tracker to start fresh", t.csvFile, t)
+	}
+	return trackerEntry{id: hit.Source.LastID, offset: hit.Source.Offset, line: hit.Source.Line}, nil
+}
+
+// Writes the tracker document if no other run changed it
+func (t *esTracker) save(entry trackerEntry) error {
+	info, err := os.Stat(t.csvFile)
+	if err != nil {
+		return fmt.Errorf("error reading CSV file info: %w", err)
+	}
+	data, err := json.Marshal(trackerState{
+		File:    t.id,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		LastID:  entry.id,
+		Offset:  entry.offset,
+		Line:    entry.line,
+	})
+	if err != nil {
+		return err
+	}
+
+	options := []func(*esapi.IndexRequest){t.es.Index.WithDocumentID(t.docID())}
+	if t.seqNo == nil {
+		options = append(options, t.es.Index.WithOpType("create"))
+	} else {
+		options = append(options, t.es.Index.WithIfSeqNo(*t.seqNo), t.es.Index.WithIfPrimaryTerm(*t.primaryTerm))
+	}
+	res, err := t.es.Index(t.index, bytes.NewReader(data), options...)
+	if err != nil {
+		return fmt.Errorf("error saving tracker %s: %w", t, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusConflict {
+		return fmt.Errorf("error saving tracker %s: %w, stopping so neither run loses progress", t, errTrackerConflict)
+	}
+	if res.IsError() {
+		return fmt.Errorf("error saving tracker %s: %s", t, res.String())
+	}
+	var saved struct {
+		SeqNo       int `json:"_seq_no"`
+		PrimaryTerm int `json:"_primary_term"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&saved); err != nil {
+		return fmt.Errorf("error parsing tracker save response: %w", err)
+	}
+	t.seqNo, t.primaryTerm = &saved.SeqNo, &saved.PrimaryTerm
+	return nil
+}
+
+// Deletes the tracker document
+func (t *esTracker) remove() (bool, error) {
+	res, err := t.es.Delete(t.index, t.docID())
+	if err != nil {
+		return false, fmt.Errorf("error deleting tracker %s: %w", t, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if res.IsError() {
+		return false, fmt.Errorf("error deleting tracker %s: %s", t, res.String())
+	}
+	t.seqNo, t.primaryTerm = nil, nil
+	return true, nil
+}
+
+func (t *esTracker) String() string {
+	return t.index + "/" + t.id
+}
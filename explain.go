@@ -0,0 +1,99 @@
+package eslocationseed
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+)
+
+// Prints the first n documents of the files with the action line they would
+// be sent with, indented for reading. The rows left out by the filters are
+// skipped silently, the rows that fail to build are printed with their error.
+func (imp *importer) explainBatch(w io.Writer, n int) error {
+	printed := 0
+	for _, csvFile := range imp.cfg.CSVFiles {
+		if printed >= n {
+			break
+		}
+		file, err := imp.openCSV(csvFile)
+		if err != nil {
+			return fmt.Errorf("%s: error opening CSV file: %w", csvFile, err)
+		}
+		reader := imp.newReader(file, nil)
+		header, err := reader.Read()
+		if err == nil {
+			err = imp.mapHeader(header)
+		}
+		for err == nil && printed < n {
+			var record []string
+			record, err = reader.Read()
+			if err != nil {
+				break
+			}
+			line, _ := reader.FieldPos(0)
+			if (imp.cfg.LenientRows && isEmptyRecord(record)) || !imp.matchFilters(record) {
+				continue
+			}
+			if imp.timestampCol >= 0 {
+				if older, sinceErr := imp.olderThanSince(record); sinceErr == nil && older {
+					continue
+				}
+			}
+			printed++
+			fmt.Fprintf(w, "# %s line %d\n", csvFile, line)
+			item, buildErr := imp.newItem(record)
+			if buildErr != nil {
+				fmt.Fprintf(w, "# error: %s\n", buildErr)
+				continue
+			}
+			if err = writeExplainedItem(w, item); err != nil {
+				break
+			}
+		}
+		file.Close()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s: %w", csvFile, err)
+		}
+	}
+	return nil
+}
+
+// Writes the action line of an item and its body, indented
+func writeExplainedItem(w io.Writer, item esutil.BulkIndexerItem) error {
+	meta := make(map[string]interface{})
+	if item.Index != "" {
+		meta["_index"] = item.Index
+	}
+	if item.DocumentID != "" {
+		meta["_id"] = item.DocumentID
+	}
+	if item.Routing != "" {
+		meta["routing"] = item.Routing
+	}
+	if item.Version != nil {
+		meta["version"] = *item.Version
+		meta["version_type"] = item.VersionType
+	}
+	action, err := json.Marshal(map[string]interface{}{item.Action: meta})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%s\n", action)
+	if item.Body == nil {
+		return nil
+	}
+	body, err := io.ReadAll(item.Body)
+	if err != nil {
+		return err
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, body, "", "  "); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", indented.Bytes())
+	return err
+}
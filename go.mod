@@ -6,6 +6,7 @@ require (
 	github.com/cheggaaa/pb/v3 v3.1.5
 	github.com/elastic/go-elasticsearch/v8 v8.15.0
 	github.com/joho/godotenv v1.5.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
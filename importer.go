@@ -0,0 +1,1288 @@
+package eslocationseed
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+)
+
+// File name reading the CSV from stdin
+const stdinFile = "-"
+
+// Returned for documents that cannot fit in a bulk request
+var errDocumentTooLarge = errors.New("document too large")
+
+// State of an import run, shared by the files it imports
+type importer struct {
+	cfg     Config
+	es      *elasticsearch.Client
+	mirrors []*mirror
+
+	// Import counters, shared by the bulk workers
+	imported   atomic.Int64
+	skipped    atomic.Int64
+	failed     atomic.Int64
+	deleted    atomic.Int64
+	notFound   atomic.Int64
+	created    atomic.Int64
+	updated    atomic.Int64
+	filtered   atomic.Int64
+	conflicts  atomic.Int64
+	stale      atomic.Int64
+	noops      atomic.Int64
+	duplicates atomic.Int64
+
+	// First duplicate _ids found by -detect-duplicate-ids, listed in the summary
+	duplicateIDs []duplicateID
+
+	// Items replaced by a later item with the same _id before being sent
+	collapsed atomic.Int64
+
+	// Rejected items waiting for the next -item-retries round, with the number
+	// of items retried and recovered
+	retryMu   sync.Mutex
+	retries   []failedItem
+	retried   atomic.Int64
+	recovered atomic.Int64
+
+	// Guards the dead-letter file, the failed IDs and the failure dumps
+	deadLetterMu sync.Mutex
+	failedIDs    []string
+
+	// Last document ID completed in the file being imported
+	lastID string
+
+	// Maps logical field names to CSV column positions, built from the header
+	cols map[string]int
+
+	// Positions of the columns composing the document _id, built from the header
+	idCols []int
+
+	// Column positions of the document fields and the builder turning rows into documents
+	columns Columns
+	builder DocumentBuilder
+
+	// Filters checked against every row, resolved against the header
+	filters []columnFilter
+
+	// Position of the routing column, -1 without routing
+	routingCol int
+
+	// Position of the column compared with -since, -1 without it
+	timestampCol int
+
+	// Position of the version column, -1 without external versions
+	versionCol int
+
+	// Slows the bulk requests down while Elasticsearch rejects them
+	throttle *throttle
+
+	// Indices known to exist, created when first used
+	ensured map[string]bool
+
+	// Rows sent for import across all files, checked against the limit
+	sent         int
+	limitReached bool
+
+	// Document bytes sent and the duration of every bulk request
+	sentBytes int64
+	latencyMu sync.Mutex
+	latencies []time.Duration
+
+	// Unix time the last bulk request completed, exposed to catch stalls
+	lastBatch atomic.Int64
+}
+
+// Returns the import counters at this point in time
+func (imp *importer) counts() counts {
+	return counts{
+		imported:   imp.imported.Load(),
+		skipped:    imp.skipped.Load(),
+		failed:     imp.failed.Load(),
+		deleted:    imp.deleted.Load(),
+		notFound:   imp.notFound.Load(),
+		filtered:   imp.filtered.Load(),
+		conflicts:  imp.conflicts.Load(),
+		stale:      imp.stale.Load(),
+		duplicates: imp.duplicates.Load(),
+		noops:      imp.noops.Load(),
+	}
+}
+
+// Imports a single CSV file, returning the lines that failed to parse
+// and whether the import was interrupted
+func (imp *importer) importFile(ctx context.Context, csvFile string) ([]int, bool, error) {
+	imp.lastID = ""
+
+	// Load last ID tracker, stdin cannot be resumed so it has none
+	var store trackerStore
+	var last trackerEntry
+	var err error
+	if csvFile == stdinFile {
+		slog.Warn("Reading CSV from stdin, an interrupted import cannot be resumed")
+	} else if imp.cfg.NoTracker {
+		slog.Info("Tracker disabled, importing from the first row", "file", csvFile)
+	} else if imp.cfg.IDStrategy == "auto" {
+		slog.Warn("Imports with IDs assigned by Elasticsearch cannot be resumed, importing from the first row", "file", csvFile)
+	} else if imp.cfg.DryRun {
+		// A dry run validates the whole file, wherever a previous import stopped
+		slog.Info("Dry run ignores the tracker, checking from the first row", "file", csvFile)
+	} else {
+		store = imp.trackerStore(csvFile)
+		last, err = store.load()
+		if err != nil {
+			return nil, false, fmt.Errorf("error retrieving last processed ID: %w", err)
+		}
+	}
+
+	// Open the CSV file
+	file, err := imp.openCSV(csvFile)
+	if err != nil {
+		return nil, false, fmt.Errorf("error opening CSV file: %w", err)
+	}
+	defer func() { file.Close() }()
+
+	reader := imp.newReader(file, nil)
+
+	// Count the lines for the progress bar, much faster than parsing the rows
+	// and matching the tracked line a resumed import starts at
+	var progressBar *pb.ProgressBar
+	if !imp.cfg.NoProgress && csvFile != stdinFile {
+		lines, err := imp.countLines(csvFile)
+		if err != nil {
+			return nil, false, fmt.Errorf("error counting lines: %w", err)
+		}
+
+		progressBar = pb.Full.Start(imp.rowCount(lines))
+		progressBar.SetRefreshRate(500 * time.Millisecond)
+	}
+
+	isStarted := last.id == ""
+
+	// Read the header
+	header, err := reader.Read()
+	if err != nil {
+		return nil, false, fmt.Errorf("error reading header: %w", err)
+	}
+	slog.Info("Read CSV header", "columns", header)
+
+	// Map field names to column positions
+	if err := imp.mapHeader(header); err != nil {
+		return nil, false, err
+	}
+
+	var duplicates *duplicateDetector
+	if imp.cfg.DetectDuplicateIDs != "" {
+		duplicates, err = imp.newDuplicateDetector(csvFile)
+		if err != nil {
+			return nil, false, fmt.Errorf("error counting rows: %w", err)
+		}
+		if last.id != "" {
+			slog.Warn("Resuming import, duplicate IDs are only detected among the remaining rows", "file", csvFile)
+		}
+	}
+
+	// Jump past the last processed record when its offset is known, otherwise
+	// scan for its ID. Offsets and lines of the new reader are relative to it.
+	var baseOffset int64
+	var baseLine int
+	if !isStarted && last.offset > 0 {
+		file.Close()
+		file, err = imp.openCSVAt(csvFile, last.offset)
+		if err != nil {
+			return nil, false, fmt.Errorf("error seeking to offset %d: %w", last.offset, err)
+		}
+		reader = imp.newReader(file, header)
+		baseOffset, baseLine = last.offset, last.line
+		isStarted = true
+		slog.Info("Resuming after last processed ID", "id", last.id, "offset", last.offset, "line", last.line)
+		if progressBar != nil {
+			progressBar.SetCurrent(int64(last.line - 1))
+		}
+	}
+
+	// Start the bulk indexer
+	tracker := newProgressTracker(store)
+	defer func() { imp.lastID = tracker.lastID(last.id) }()
+	if !imp.cfg.DryRun {
+		stop := tracker.checkpoint(imp.cfg.CheckpointInterval)
+		defer stop()
+	}
+	var bi esutil.BulkIndexer
+	if !imp.cfg.DryRun {
+		bi, err = imp.newIndexer(tracker)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	// Items still waiting for a retry when the import stops are failures
+	defer imp.failRetries()
+	var errorLines []int
+
+	seq := 0
+	rows := 0
+	started := time.Now()
+	for {
+		// Stop reading, flush pending documents and save progress on interrupt
+		select {
+		case <-ctx.Done():
+			if progressBar != nil {
+				progressBar.Finish()
+			}
+			fmt.Println("Interrupted, flushing pending documents...")
+			if err := closeIndexer(bi, tracker); err != nil {
+				return errorLines, true, err
+			}
+			if !imp.cfg.DryRun && store != nil {
+				fmt.Printf("Progress saved to %s, run again with the same arguments to resume.\n", store)
+			}
+			return errorLines, true, nil
+		default:
+		}
+
+		// Stop reading once the limit is reached, the pending documents are still flushed
+		if imp.cfg.Limit > 0 && imp.limitUsed() >= imp.cfg.Limit {
+			imp.limitReached = true
+			break
+		}
+
+		// Abort when a background flush failed
+		if err := tracker.failure(); err != nil {
+			closeIndexer(bi, tracker)
+			return errorLines, false, err
+		}
+		if err := imp.checkMaxErrors(); err != nil {
+			closeIndexer(bi, tracker)
+			return errorLines, false, err
+		}
+
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if progressBar != nil {
+			progressBar.Increment()
+		}
+		rows++
+		if imp.cfg.LogEvery > 0 && rows%imp.cfg.LogEvery == 0 {
+			imp.logProgress(rows, started)
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				parseErr.StartLine += baseLine
+				parseErr.Line += baseLine
+			}
+			if (!imp.cfg.SkipBadRows && !imp.cfg.DryRun) || parseErr == nil {
+				closeIndexer(bi, tracker)
+				return errorLines, false, fmt.Errorf("error reading CSV file: %w", err)
+			}
+			imp.skipped.Add(1)
+			errorLines = append(errorLines, parseErr.StartLine)
+			// A quote left open swallows the following lines, report how far it reached
+			slog.Warn("Skipping row", "line", parseErr.StartLine, "end_line", parseErr.Line, "error", parseErr.Err, "record", record)
+			continue
+		}
+
+		// Line of the record in the whole file, reported with every error about it
+		line, _ := reader.FieldPos(0)
+		line += baseLine
+
+		if imp.cfg.LenientRows && isEmptyRecord(record) {
+			imp.skipped.Add(1)
+			slog.Debug("Skipping empty row", "line", line)
+			continue
+		}
+
+		if !isStarted && imp.documentID(record) == last.id {
+			isStarted = true
+			continue
+		}
+
+		if isStarted && !imp.matchFilters(record) {
+			imp.filtered.Add(1)
+			continue
+		}
+
+		// Rows unchanged since the previous run are left out before building them
+		if isStarted && imp.timestampCol >= 0 {
+			older, err := imp.olderThanSince(record)
+			if err != nil {
+				if !imp.cfg.SkipBadRows && !imp.cfg.DryRun {
+					closeIndexer(bi, tracker)
+					return errorLines, false, fmt.Errorf("error parsing line %d: %w", line, err)
+				}
+				imp.skipped.Add(1)
+				errorLines = append(errorLines, line)
+				slog.Warn("Skipping row", "line", line, "error", err, "record", record)
+				continue
+			}
+			if older {
+				imp.filtered.Add(1)
+				continue
+			}
+		}
+
+		if isStarted {
+			// Create the bulk item for the row
+			item, err := imp.newItem(record)
+			if err == nil && imp.cfg.LazyQuotes {
+				err = checkOpenQuote(record, len(header), imp.cfg.Delimiter)
+			}
+			if errors.Is(err, errDocumentTooLarge) {
+				// Elasticsearch would reject the whole request, so skip the row even when strict
+				imp.skipped.Add(1)
+				slog.Warn("Skipping oversized row", "line", line, "id", imp.documentID(record), "error", err)
+				if err := imp.writeDeadLetter(imp.documentID(record)); err != nil {
+					slog.Error("Error writing dead-letter file", "error", err)
+				}
+				continue
+			}
+			if err != nil {
+				if !imp.cfg.SkipBadRows && !imp.cfg.DryRun {
+					closeIndexer(bi, tracker)
+					return errorLines, false, fmt.Errorf("error parsing line %d: %w", line, err)
+				}
+				imp.skipped.Add(1)
+				errorLines = append(errorLines, line)
+				slog.Warn("Skipping row", "line", line, "error", err, "record", record)
+				continue
+			}
+
+			// Indices named after the rows are created when first used
+			if imp.cfg.CreateIndex && !imp.cfg.DryRun && !imp.ensured[item.Index] {
+				if err := imp.ensureIndex(item.Index); err != nil {
+					closeIndexer(bi, tracker)
+					return errorLines, false, fmt.Errorf("error preparing index %s: %w", item.Index, err)
+				}
+				imp.ensured[item.Index] = true
+			}
+
+			if duplicates != nil && item.DocumentID != "" {
+				// Rows named after their values can land the same _id in different indices
+				if first, seen := duplicates.check(item.Index+"/"+item.DocumentID, line); seen {
+					imp.addDuplicate(duplicateID{id: item.DocumentID, line: line, firstLine: first})
+				}
+			}
+
+			imp.sent++
+			if imp.cfg.DryRun {
+				imp.imported.Add(1)
+				continue
+			}
+
+			// Queue the item, the indexer flushes by size and interval
+			endLine, _ := reader.FieldPos(len(record) - 1)
+			entry := trackerEntry{
+				id:     item.DocumentID,
+				offset: baseOffset + reader.InputOffset(),
+				line:   baseLine + endLine,
+			}
+			slog.Debug("Queued document", "line", line, "action", item.Action, "id", item.DocumentID)
+			itemSeq := seq
+			seq++
+			item.OnSuccess = func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
+				imp.countSuccess(ctx, item, res)
+				tracker.done(itemSeq, entry)
+			}
+			item.OnFailure = func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+				imp.countFailure(ctx, item, res, err, line, 0)
+				tracker.done(itemSeq, entry)
+			}
+			if body, ok := item.Body.(*bytes.Reader); ok {
+				imp.sentBytes += int64(body.Len())
+			}
+			err = bi.Add(context.Background(), item)
+			if err != nil {
+				closeIndexer(bi, tracker)
+				return errorLines, false, fmt.Errorf("error adding document to bulk indexer: %w", err)
+			}
+		}
+	}
+
+	// Send remaining requests, then save the ID of the last row they completed
+	err = closeIndexer(bi, tracker)
+	if err == nil {
+		err = imp.retryFailedItems(ctx, tracker)
+	}
+
+	if progressBar != nil {
+		progressBar.Finish()
+	}
+	if err == nil {
+		err = imp.checkMaxErrors()
+	}
+	return errorLines, false, err
+}
+
+// Returns the rows indexed or still pending, the rows Elasticsearch did not
+// index leaving room under the -limit for the following rows
+func (imp *importer) limitUsed() int {
+	missed := imp.failed.Load() + imp.stale.Load() + imp.collapsed.Load() + imp.notFound.Load()
+	return imp.sent - int(missed)
+}
+
+// Reports whether the row was last updated before -since
+func (imp *importer) olderThanSince(record []string) (bool, error) {
+	var value string
+	if imp.timestampCol < len(record) {
+		value = strings.TrimSpace(record[imp.timestampCol])
+	}
+	if value == "" {
+		return false, fmt.Errorf("missing %s timestamp", imp.cfg.TimestampField)
+	}
+	timestamp, err := time.Parse(imp.cfg.DateLayout, value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s timestamp: %w", imp.cfg.TimestampField, err)
+	}
+	return timestamp.Before(imp.cfg.Since), nil
+}
+
+// Creates the duplicate detector of a file, sizing the bloom filter for its rows
+func (imp *importer) newDuplicateDetector(csvFile string) (*duplicateDetector, error) {
+	rows := 0
+	if imp.cfg.DetectDuplicateIDs == "bloom" && csvFile != stdinFile {
+		lines, err := imp.countLines(csvFile)
+		if err != nil {
+			return nil, err
+		}
+		rows = imp.rowCount(lines)
+	}
+	return newDuplicateDetector(imp.cfg.DetectDuplicateIDs, rows), nil
+}
+
+// Counts a row repeating an _id, keeping the first ones for the summary
+func (imp *importer) addDuplicate(dup duplicateID) {
+	imp.duplicates.Add(1)
+	if len(imp.duplicateIDs) < maxDuplicateExamples {
+		imp.duplicateIDs = append(imp.duplicateIDs, dup)
+	}
+	if dup.firstLine > 0 {
+		slog.Warn("Duplicate ID", "id", dup.id, "line", dup.line, "first_line", dup.firstLine)
+	} else {
+		slog.Warn("Possible duplicate ID", "id", dup.id, "line", dup.line)
+	}
+}
+
+// Maps the fields and options naming columns to their positions in the header
+func (imp *importer) mapHeader(header []string) error {
+	var err error
+	positions := headerPositions(header)
+	if err := checkHeader(positions, imp.cfg.ExpectedHeader); err != nil {
+		if !imp.cfg.IgnoreHeaderMismatch {
+			return fmt.Errorf("unexpected CSV header: %w", err)
+		}
+		slog.Warn("CSV header does not match -expected-header", "error", err)
+	}
+	imp.cols, err = mapColumns(positions, imp.cfg)
+	if err != nil {
+		return fmt.Errorf("error mapping CSV columns: %w", err)
+	}
+	imp.idCols, err = mapIDColumns(positions, imp.cfg)
+	if err != nil {
+		return fmt.Errorf("error mapping CSV columns: %w", err)
+	}
+	imp.columns.Bools, err = mapBoolColumns(positions, imp.cols, imp.cfg)
+	if err != nil {
+		return fmt.Errorf("error mapping CSV columns: %w", err)
+	}
+	imp.columns.Dates, err = mapDateColumns(positions, imp.cols, imp.cfg)
+	if err != nil {
+		return fmt.Errorf("error mapping CSV columns: %w", err)
+	}
+	imp.columns.Fields = imp.cols
+	imp.filters, err = mapFilters(positions, imp.cols, imp.cfg.Filters)
+	if err != nil {
+		return fmt.Errorf("error mapping CSV columns: %w", err)
+	}
+	imp.routingCol = -1
+	if imp.cfg.RoutingField != "" {
+		i, ok := positions[imp.cfg.RoutingField]
+		if !ok {
+			return fmt.Errorf("error mapping CSV columns: missing routing column in CSV header: %s", imp.cfg.RoutingField)
+		}
+		imp.routingCol = i
+	}
+
+	imp.timestampCol = -1
+	if imp.cfg.TimestampField != "" {
+		i, ok := positions[imp.cfg.TimestampField]
+		if !ok {
+			return fmt.Errorf("error mapping CSV columns: missing timestamp column in CSV header: %s", imp.cfg.TimestampField)
+		}
+		imp.timestampCol = i
+	}
+
+	imp.versionCol = -1
+	if imp.cfg.VersionField != "" {
+		i, ok := positions[imp.cfg.VersionField]
+		if !ok {
+			return fmt.Errorf("error mapping CSV columns: missing version column in CSV header: %s", imp.cfg.VersionField)
+		}
+		imp.versionCol = i
+	}
+	return nil
+}
+
+// Fails once more rows were skipped or rejected than -max-errors allows
+func (imp *importer) checkMaxErrors() error {
+	if imp.cfg.MaxErrors <= 0 {
+		return nil
+	}
+	if bad := imp.skipped.Load() + imp.failed.Load(); bad > int64(imp.cfg.MaxErrors) {
+		return fmt.Errorf("aborting after %d skipped or rejected rows, more than -max-errors %d; check -delimiter, -columns and the CSV header match the file", bad, imp.cfg.MaxErrors)
+	}
+	return nil
+}
+
+// Filter resolved to the position of its column
+type columnFilter struct {
+	col    int
+	values []string
+}
+
+// Resolves the filter fields to mapped fields or, failing that, to CSV columns
+func mapFilters(positions map[string]int, cols map[string]int, specs []string) ([]columnFilter, error) {
+	var filters []columnFilter
+	for _, spec := range specs {
+		filter, err := parseFilter(spec)
+		if err != nil {
+			return nil, err
+		}
+		i, ok := cols[filter.field]
+		if !ok {
+			i, ok = positions[filter.field]
+		}
+		if !ok {
+			return nil, fmt.Errorf("missing filter column in CSV header: %s", filter.field)
+		}
+		filters = append(filters, columnFilter{col: i, values: filter.values})
+	}
+	return filters, nil
+}
+
+// Returns whether the record matches all filters
+func (imp *importer) matchFilters(record []string) bool {
+	for _, filter := range imp.filters {
+		if filter.col >= len(record) || !slices.Contains(filter.values, strings.TrimSpace(record[filter.col])) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns where the resume state of a CSV file is kept
+func (imp *importer) trackerStore(csvFile string) trackerStore {
+	if imp.cfg.TrackerBackend == "es" {
+		return newESTracker(imp.es, imp.cfg.TrackerIndex, csvFile)
+	}
+	return fileTracker{csvFile: csvFile, path: imp.trackerPath(csvFile)}
+}
+
+// Returns the tracker path of a CSV file
+func (imp *importer) trackerPath(csvFile string) string {
+	if imp.cfg.TrackerFile != "" {
+		return imp.cfg.TrackerFile
+	}
+	return getTrackerFileName(csvFile)
+}
+
+// Logs the number of rows processed so far and the processing rate
+func (imp *importer) logProgress(rows int, started time.Time) {
+	rate := float64(rows) / time.Since(started).Seconds()
+	slog.Info("Progress", "rows", rows, "imported", imp.imported.Load(), "skipped", imp.skipped.Load(), "failed", imp.failed.Load(), "rows_per_sec", int(rate))
+}
+
+// Context key of the batch being flushed
+type batchKey struct{}
+
+// Outcome of a single bulk request, updated by the worker flushing it
+type batchStats struct {
+	started  time.Time
+	items    int
+	failed   int
+	rejected int
+	serial   bool
+
+	// Ends the -request-timeout deadline of the request
+	cancel context.CancelFunc
+
+	// Items rejected because their index does not exist, and the last such index
+	missing      int
+	missingIndex string
+}
+
+// Counts an item in the batch it was flushed with
+func countBatchItem(ctx context.Context, failed bool) {
+	if batch, ok := ctx.Value(batchKey{}).(*batchStats); ok {
+		batch.items++
+		if failed {
+			batch.failed++
+		}
+	}
+}
+
+// Creates the bulk indexer that saves progress after every flush
+func (imp *importer) newIndexer(tracker *progressTracker) (esutil.BulkIndexer, error) {
+	var bi esutil.BulkIndexer
+	var err error
+	if imp.cfg.BulkSize > 0 {
+		bi, err = newCountIndexer(func() (esutil.BulkIndexer, error) { return imp.newBulkIndexer(tracker) }, imp.cfg.BulkSize)
+	} else {
+		bi, err = imp.newBulkIndexer(tracker)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if imp.cfg.DedupBatch {
+		return newDedupIndexer(bi, imp.cfg.FlushBytes), nil
+	}
+	return bi, nil
+}
+
+// Creates the esutil bulk indexer sending the documents
+func (imp *importer) newBulkIndexer(tracker *progressTracker) (esutil.BulkIndexer, error) {
+	bi, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
+		Client:              imp.es,
+		NumWorkers:          imp.cfg.Workers,
+		FlushBytes:          imp.cfg.FlushBytes,
+		FlushInterval:       imp.cfg.FlushInterval,
+		Pipeline:            imp.cfg.Pipeline,
+		Refresh:             imp.cfg.Refresh,
+		WaitForActiveShards: imp.cfg.WaitForActiveShards,
+		// The items only need their outcome, which keeps the decoded responses small
+		FilterPath: []string{"took", "errors", "items.*.status", "items.*.result", "items.*.error"},
+		OnError: func(ctx context.Context, err error) {
+			tracker.fail(fmt.Errorf("error executing bulk request: %w", err))
+		},
+		OnFlushStart: func(ctx context.Context) context.Context {
+			serial := imp.throttle.wait(ctx)
+			batch := &batchStats{started: time.Now(), serial: serial, cancel: func() {}}
+			// The deadline covers the attempts of the transport, once past it
+			// the request fails instead of being retried
+			if imp.cfg.RequestTimeout > 0 {
+				ctx, batch.cancel = context.WithTimeout(ctx, imp.cfg.RequestTimeout)
+			}
+			return context.WithValue(ctx, batchKey{}, batch)
+		},
+		OnFlushEnd: func(ctx context.Context) {
+			batch, ok := ctx.Value(batchKey{}).(*batchStats)
+			if ok {
+				batch.cancel()
+			}
+			if ok && batch.serial {
+				imp.throttle.serial.Unlock()
+			}
+			if ok && batch.rejected > 0 {
+				imp.throttle.pressure()
+			} else if ok && batch.items > 0 {
+				imp.throttle.relief()
+			}
+
+			// Flushes of an empty buffer still call the hooks, they sent nothing
+			if ok && batch.items > 0 {
+				duration := time.Since(batch.started)
+				imp.latencyMu.Lock()
+				imp.latencies = append(imp.latencies, duration)
+				imp.latencyMu.Unlock()
+				imp.lastBatch.Store(time.Now().Unix())
+				slog.Debug("Batch sent",
+					"batch_size", batch.items,
+					"failed", batch.failed,
+					"duration_ms", duration.Milliseconds(),
+					"imported", imp.imported.Load())
+			}
+			// Every following batch would fail the same way, stop before sending them
+			if ok && batch.items > 0 && batch.missing == batch.items && imp.imported.Load() == 0 {
+				tracker.fail(fmt.Errorf("every document was rejected because index %s does not exist and the cluster does not create indices automatically, use -create-index or enable action.auto_create_index", batch.missingIndex))
+			}
+			if err := tracker.save(); err != nil {
+				tracker.fail(err)
+			}
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating bulk indexer: %w", err)
+	}
+	return bi, nil
+}
+
+// Builds the bulk item for a CSV record, a delete or a document to index
+func (imp *importer) newItem(record []string) (esutil.BulkIndexerItem, error) {
+	id := imp.documentID(record)
+	if imp.cfg.Delete {
+		if id == "" {
+			return esutil.BulkIndexerItem{}, fmt.Errorf("missing document id")
+		}
+	}
+	index, err := imp.indexName(record)
+	if err != nil {
+		return esutil.BulkIndexerItem{}, err
+	}
+	version, err := imp.version(record)
+	if err != nil {
+		return esutil.BulkIndexerItem{}, err
+	}
+	if imp.cfg.Delete {
+		return esutil.BulkIndexerItem{Action: "delete", Index: index, DocumentID: id, Routing: imp.routing(record), Version: version, VersionType: imp.versionType()}, nil
+	}
+
+	document, err := imp.builder.BuildDocument(record, imp.columns)
+	if err != nil {
+		return esutil.BulkIndexerItem{}, err
+	}
+	if imp.cfg.IDEncoding != "raw" && id != "" {
+		document[imp.cfg.IDOriginalField] = imp.originalID(record)
+	}
+	action, body := imp.bulkAction(document)
+	if len(body) > imp.cfg.MaxRequestBytes {
+		return esutil.BulkIndexerItem{}, fmt.Errorf("%w: %d bytes, -max-request-bytes is %d", errDocumentTooLarge, len(body), imp.cfg.MaxRequestBytes)
+	}
+	return esutil.BulkIndexerItem{
+		Action:      action,
+		Index:       index,
+		DocumentID:  id,
+		Routing:     imp.routing(record),
+		Version:     version,
+		VersionType: imp.versionType(),
+		Body:        bytes.NewReader(body),
+	}, nil
+}
+
+// Returns the external version of a record, nil without -version-field
+func (imp *importer) version(record []string) (*int64, error) {
+	if imp.versionCol < 0 {
+		return nil, nil
+	}
+	if imp.versionCol >= len(record) {
+		return nil, fmt.Errorf("missing %s field, record has %d columns", imp.cfg.VersionField, len(record))
+	}
+	version, err := strconv.ParseInt(strings.TrimSpace(record[imp.versionCol]), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s field: %w", imp.cfg.VersionField, err)
+	}
+	return &version, nil
+}
+
+// Returns the version type sent with the versions, empty without them
+func (imp *importer) versionType() string {
+	if imp.cfg.VersionField == "" {
+		return ""
+	}
+	return imp.cfg.VersionType
+}
+
+// Returns the routing value of a record, empty without routing
+func (imp *importer) routing(record []string) string {
+	if imp.routingCol < 0 || imp.routingCol >= len(record) {
+		return ""
+	}
+	return record[imp.routingCol]
+}
+
+// Returns the bulk action and its body for a document
+func (imp *importer) bulkAction(document map[string]interface{}) (string, []byte) {
+	if imp.cfg.Upsert {
+		body, _ := json.Marshal(map[string]interface{}{
+			"doc":           document,
+			"doc_as_upsert": true,
+			"detect_noop":   imp.cfg.DetectNoop,
+		})
+		return "update", body
+	}
+	body, _ := json.Marshal(document)
+	return imp.cfg.OpType, body
+}
+
+// Flushes the remaining documents and saves the final progress
+func closeIndexer(bi esutil.BulkIndexer, tracker *progressTracker) error {
+	if bi == nil {
+		return nil
+	}
+	if err := bi.Close(context.Background()); err != nil {
+		return fmt.Errorf("error closing bulk indexer: %w", err)
+	}
+	if err := tracker.failure(); err != nil {
+		return err
+	}
+	return tracker.save()
+}
+
+// Counts a document accepted by Elasticsearch
+func (imp *importer) countSuccess(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
+	countBatchItem(ctx, false)
+	if item.Action == "delete" {
+		imp.deleted.Add(1)
+	} else if res.Result == "created" {
+		imp.imported.Add(1)
+		imp.created.Add(1)
+	} else if res.Result == "noop" {
+		// Accepted, but the document already had these values
+		imp.imported.Add(1)
+		imp.noops.Add(1)
+	} else {
+		imp.imported.Add(1)
+		imp.updated.Add(1)
+	}
+}
+
+// Counts an item that did not succeed, queueing the rejected documents for
+// another attempt while -item-retries allows
+func (imp *importer) countFailure(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error, line, attempt int) {
+	if res.Status == http.StatusConflict && imp.cfg.VersionField != "" {
+		// The index already has this or a newer version, which is expected
+		countBatchItem(ctx, false)
+		imp.stale.Add(1)
+	} else if errors.Is(err, errDuplicateCollapsed) {
+		imp.collapsed.Add(1)
+		if body, ok := item.Body.(*bytes.Reader); ok {
+			imp.sentBytes -= int64(body.Len())
+		}
+	} else if item.Action == "delete" && res.Status == http.StatusNotFound {
+		countBatchItem(ctx, false)
+		imp.notFound.Add(1)
+	} else {
+		countBatchItem(ctx, true)
+		if batch, ok := ctx.Value(batchKey{}).(*batchStats); ok && res.Status == http.StatusTooManyRequests {
+			batch.rejected++
+		} else if ok && res.Error.Type == "index_not_found_exception" {
+			batch.missing++
+			batch.missingIndex = cmp.Or(item.Index, imp.cfg.Index)
+		}
+		// Conflicts stay conflicts however often they are retried
+		if attempt < imp.cfg.ItemRetries && res.Status != http.StatusConflict {
+			imp.queueRetry(failedItem{item: item, res: res, err: err, line: line}, attempt == 0)
+			return
+		}
+		imp.handleItemFailure(item, res, err, line)
+	}
+}
+
+// Item rejected by Elasticsearch, waiting for another attempt
+type failedItem struct {
+	item esutil.BulkIndexerItem
+	res  esutil.BulkIndexerResponseItem
+	err  error
+	line int
+}
+
+// Queues a rejected item for the next retry round
+func (imp *importer) queueRetry(f failedItem, first bool) {
+	imp.retryMu.Lock()
+	defer imp.retryMu.Unlock()
+
+	imp.retries = append(imp.retries, f)
+	if first {
+		imp.retried.Add(1)
+	}
+}
+
+// Puts back items taken for a retry round that did not run
+func (imp *importer) requeue(items []failedItem) {
+	imp.retryMu.Lock()
+	defer imp.retryMu.Unlock()
+
+	imp.retries = append(imp.retries, items...)
+}
+
+// Takes the items queued for the next retry round
+func (imp *importer) takeRetries() []failedItem {
+	imp.retryMu.Lock()
+	defer imp.retryMu.Unlock()
+
+	items := imp.retries
+	imp.retries = nil
+	return items
+}
+
+// Resubmits the rejected items after backing off, up to -item-retries times
+func (imp *importer) retryFailedItems(ctx context.Context, tracker *progressTracker) error {
+	for attempt := 1; attempt <= imp.cfg.ItemRetries; attempt++ {
+		items := imp.takeRetries()
+		if len(items) == 0 {
+			return nil
+		}
+		delay := max(retryDelay(attempt), imp.throttle.current())
+		slog.Info("Retrying rejected documents", "documents", len(items), "attempt", attempt, "delay", delay.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			imp.requeue(items)
+			return nil
+		case <-time.After(delay):
+		}
+
+		bi, err := imp.newIndexer(tracker)
+		if err != nil {
+			imp.requeue(items)
+			return err
+		}
+		for _, f := range items {
+			item, line, attempt := resubmitted(f.item), f.line, attempt
+			item.OnSuccess = func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
+				imp.countSuccess(ctx, item, res)
+				imp.recovered.Add(1)
+			}
+			item.OnFailure = func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+				imp.countFailure(ctx, item, res, err, line, attempt)
+			}
+			if err := bi.Add(context.Background(), item); err != nil {
+				closeIndexer(bi, tracker)
+				return fmt.Errorf("error adding document to bulk indexer: %w", err)
+			}
+		}
+		if err := closeIndexer(bi, tracker); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copies an item for another Add, which would otherwise append its action
+// line to the one left by the previous Add
+func resubmitted(item esutil.BulkIndexerItem) esutil.BulkIndexerItem {
+	if item.Body != nil {
+		item.Body.Seek(0, io.SeekStart)
+	}
+	return esutil.BulkIndexerItem{
+		Index:           item.Index,
+		Action:          item.Action,
+		DocumentID:      item.DocumentID,
+		Routing:         item.Routing,
+		RequireAlias:    item.RequireAlias,
+		Version:         item.Version,
+		VersionType:     item.VersionType,
+		Body:            item.Body,
+		RetryOnConflict: item.RetryOnConflict,
+		IfSeqNo:         item.IfSeqNo,
+		IfPrimaryTerm:   item.IfPrimaryTerm,
+	}
+}
+
+// Counts the items left waiting for a retry as rejected
+func (imp *importer) failRetries() {
+	for _, f := range imp.takeRetries() {
+		imp.handleItemFailure(f.item, f.res, f.err, f.line)
+	}
+}
+
+// Counts and logs a document rejected by Elasticsearch
+func (imp *importer) handleItemFailure(item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error, line int) {
+	imp.failed.Add(1)
+	if res.Status == http.StatusConflict {
+		imp.conflicts.Add(1)
+	}
+
+	reason := http.StatusText(res.Status)
+	if err != nil {
+		reason = err.Error()
+	} else if res.Error.Type != "" {
+		reason = fmt.Sprintf("%s: %s", res.Error.Type, res.Error.Reason)
+	}
+	slog.Warn("Document rejected", "line", line, "action", item.Action, "id", item.DocumentID, "status", res.Status, "reason", reason)
+	if imp.cfg.Pretty {
+		imp.dumpFailure(item, res, reason, line)
+	}
+	if err := imp.writeDeadLetter(item.DocumentID); err != nil {
+		slog.Error("Error writing dead-letter file", "error", err)
+	}
+}
+
+// Prints a rejected action with its document and the error, indented for reading
+func (imp *importer) dumpFailure(item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, reason string, line int) {
+	var document json.RawMessage
+	if item.Body != nil {
+		if _, err := item.Body.Seek(0, io.SeekStart); err == nil {
+			if body, err := io.ReadAll(item.Body); err == nil && json.Valid(body) {
+				document = body
+			}
+		}
+	}
+	dump, err := json.MarshalIndent(map[string]interface{}{
+		"line":     line,
+		"action":   map[string]interface{}{item.Action: map[string]string{"_index": item.Index, "_id": item.DocumentID}},
+		"document": document,
+		"status":   res.Status,
+		"error":    reason,
+	}, "", "  ")
+	if err != nil {
+		return
+	}
+	imp.deadLetterMu.Lock()
+	defer imp.deadLetterMu.Unlock()
+	fmt.Fprintf(os.Stderr, "%s\n", dump)
+}
+
+// Records a failed document ID for the report and appends it to the
+// dead-letter file, if configured
+func (imp *importer) writeDeadLetter(id string) error {
+	imp.deadLetterMu.Lock()
+	defer imp.deadLetterMu.Unlock()
+
+	if imp.cfg.Report != "" {
+		imp.failedIDs = append(imp.failedIDs, id)
+	}
+	if imp.cfg.DeadLetterFile == "" {
+		return nil
+	}
+
+	file, err := os.OpenFile(imp.cfg.DeadLetterFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = fmt.Fprintln(file, id)
+	return err
+}
+
+// Returns the index of a record, substituting the {field} placeholders of the
+// -es-index template with the lowercased field values
+func (imp *importer) indexName(record []string) (string, error) {
+	if !indexPlaceholder.MatchString(imp.cfg.Index) {
+		return imp.cfg.Index, nil
+	}
+	var err error
+	name := indexPlaceholder.ReplaceAllStringFunc(imp.cfg.Index, func(placeholder string) string {
+		i, ok := imp.cols[placeholder[1:len(placeholder)-1]]
+		if !ok || i >= len(record) {
+			err = fmt.Errorf("missing %s field for index name", placeholder)
+			return ""
+		}
+		return strings.ToLower(strings.TrimSpace(record[i]))
+	})
+	if err != nil {
+		return "", err
+	}
+	return name, validIndexName(name)
+}
+
+// Returns the document _id for the -id-strategy, empty for Elasticsearch to
+// assign one
+func (imp *importer) documentID(record []string) string {
+	switch imp.cfg.IDStrategy {
+	case "auto":
+		return ""
+	case "hash-of-row":
+		return imp.rowHash(record)
+	}
+	return encodeID(imp.originalID(record), imp.cfg.IDEncoding)
+}
+
+// Returns the hex SHA-1 of the -id-fields columns, or of the whole row without
+// them, the same row always hashing to the same _id
+func (imp *importer) rowHash(record []string) string {
+	values := record
+	if len(imp.idCols) > 0 {
+		values = make([]string, len(imp.idCols))
+		for i, col := range imp.idCols {
+			if col >= len(record) {
+				return ""
+			}
+			values[i] = record[col]
+		}
+	}
+	// A separator unlikely in CSV values keeps "a,bc" and "ab,c" apart
+	sum := sha1.Sum([]byte(strings.Join(values, "\x1f")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Returns the _id value of a record before encoding, joining the -id-fields
+// columns when configured
+func (imp *importer) originalID(record []string) string {
+	if len(imp.idCols) == 0 {
+		if imp.cols["id"] >= len(record) {
+			return ""
+		}
+		return record[imp.cols["id"]]
+	}
+
+	parts := make([]string, len(imp.idCols))
+	for i, col := range imp.idCols {
+		if col >= len(record) {
+			return ""
+		}
+		parts[i] = record[col]
+	}
+	return strings.Join(parts, imp.cfg.IDSeparator)
+}
+
+// Encodes an _id so slashes, spaces and other characters need no escaping in
+// URLs, an empty _id staying empty for Elasticsearch to generate one
+func encodeID(id, encoding string) string {
+	if id == "" {
+		return ""
+	}
+	switch encoding {
+	case "urlsafe":
+		return base64.RawURLEncoding.EncodeToString([]byte(id))
+	case "sha1":
+		sum := sha1.Sum([]byte(id))
+		return hex.EncodeToString(sum[:])
+	}
+	return id
+}
+
+// Opens the CSV file, decompressing gzipped input
+func (imp *importer) openCSV(path string) (io.ReadCloser, error) {
+	file := os.Stdin
+	if path != stdinFile {
+		var err error
+		file, err = os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !imp.cfg.Gzip && !strings.HasSuffix(path, ".gz") {
+		return file, nil
+	}
+
+	gz, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error reading gzip stream: %w", err)
+	}
+	return &gzipFile{Reader: gz, file: file}, nil
+}
+
+// Opens the CSV file positioned at a byte offset of its uncompressed content
+func (imp *importer) openCSVAt(path string, offset int64) (io.ReadCloser, error) {
+	file, err := imp.openCSV(path)
+	if err != nil {
+		return nil, err
+	}
+	// Plain files seek directly, gzip streams have to be decompressed up to the offset
+	if seeker, ok := file.(io.Seeker); ok {
+		_, err = seeker.Seek(offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, file, offset)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Creates a reader for the -input-format, given the header already read when
+// resuming past the start of the file
+func (imp *importer) newReader(file io.Reader, header []string) recordReader {
+	if imp.cfg.InputFormat == "ndjson" {
+		return newNDJSONReader(file, header, imp.cfg)
+	}
+	return imp.newCSVReader(file)
+}
+
+// Returns the number of rows in a file of that many lines, CSV files having a header
+func (imp *importer) rowCount(lines int) int {
+	if imp.cfg.InputFormat == "ndjson" {
+		return lines
+	}
+	return max(lines-1, 0)
+}
+
+// Creates a CSV reader, comma-separated with strict quoting by default
+func (imp *importer) newCSVReader(file io.Reader) *csv.Reader {
+	reader := csv.NewReader(bufio.NewReader(file))
+	reader.Comma = imp.cfg.Delimiter
+	reader.LazyQuotes = imp.cfg.LazyQuotes
+	if imp.cfg.LenientRows {
+		reader.FieldsPerRecord = -1
+	}
+	return reader
+}
+
+// Detects a field that swallowed whole rows because an opening quote was never
+// closed, which lazy quotes otherwise accept as one shifted record. Files with
+// fewer than 4 columns are not checked, a value holding a delimiter or two
+// being indistinguishable from a row there.
+func checkOpenQuote(record []string, columns int, delimiter rune) error {
+	if columns < 4 {
+		return nil
+	}
+	for i, field := range record {
+		if !strings.ContainsRune(field, '\n') {
+			continue
+		}
+		for _, line := range strings.Split(field, "\n") {
+			if strings.Count(line, string(delimiter)) >= columns-1 {
+				return fmt.Errorf("field %d contains complete rows, a quote is likely never closed", i+1)
+			}
+		}
+	}
+	return nil
+}
+
+// Reports whether every field of the record is blank
+func isEmptyRecord(record []string) bool {
+	for _, field := range record {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Closes both the gzip stream and the underlying file
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g *gzipFile) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
+
+// Counts the lines of the CSV file without parsing it, a quoted field
+// spanning several lines counts once per line
+func (imp *importer) countLines(csvFile string) (int, error) {
+	file, err := imp.openCSV(csvFile)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, 64*1024)
+	count := 0
+	last := byte('\n')
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			count += bytes.Count(buf[:n], []byte{'\n'})
+			last = buf[n-1]
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	// The last line needs no newline
+	if last != '\n' {
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,505 @@
+package eslocationseed
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Bulk action received by the test server, with its document
+type receivedAction struct {
+	op    string
+	meta  map[string]interface{}
+	doc   map[string]interface{}
+	query string
+}
+
+// Elasticsearch stand-in recording the bulk actions it receives, every item
+// succeeds
+type bulkServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	actions []receivedAction
+	// Called before each bulk request is answered
+	onBulk func()
+}
+
+func newBulkServer(t *testing.T) *bulkServer {
+	s := &bulkServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *bulkServer) handle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	w.Header().Set("Content-Type", "application/json")
+	if !strings.HasSuffix(r.URL.Path, "/_bulk") {
+		fmt.Fprint(w, `{"version":{"number":"8.15.0"}}`)
+		return
+	}
+
+	var batch []receivedAction
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(nil, 1<<24)
+	for scanner.Scan() {
+		var action map[string]map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for op, meta := range action {
+			a := receivedAction{op: op, meta: meta, query: r.URL.RawQuery}
+			if op != "delete" && scanner.Scan() {
+				if err := json.Unmarshal(scanner.Bytes(), &a.doc); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			batch = append(batch, a)
+		}
+	}
+
+	if s.onBulk != nil {
+		s.onBulk()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := make([]map[string]interface{}, len(batch))
+	for i, a := range batch {
+		items[i] = map[string]interface{}{a.op: map[string]interface{}{"_id": a.meta["_id"], "status": http.StatusCreated, "result": "created"}}
+		s.actions = append(s.actions, a)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"took": 1, "errors": false, "items": items})
+}
+
+// Returns the actions received so far
+func (s *bulkServer) received() []receivedAction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]receivedAction(nil), s.actions...)
+}
+
+// Header of the CSV files written by the tests, the default column names
+const testHeader = "id,address,city,country,district,division,isAutocompleteAddress,latlng,placeId,plusCode,postalCode,types"
+
+// Writes a CSV file with the test header and the given rows
+func writeTestCSV(t *testing.T, rows ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "locations.csv")
+	content := testHeader + "\n" + strings.Join(rows, "\n") + "\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Returns a valid CSV row with the given id
+func testRow(id int) string {
+	return fmt.Sprintf("%d,Road %d,Dhaka,BD,Dhaka,Dhaka,true,POINT (90.4125 23.8103),p%d,7MMG+XX,1207,road;street", id, id, id)
+}
+
+// Returns the configuration of a run against the test server
+func testConfig(s *bulkServer, csvFile string) Config {
+	cfg := DefaultConfig()
+	cfg.ESURL = s.URL
+	cfg.Index = "locations"
+	cfg.CSVFiles = []string{csvFile}
+	cfg.NoProgress = true
+	cfg.CreateIndex = false
+	return cfg
+}
+
+// Returns the last ID saved by the tracker of a CSV file
+func trackedID(t *testing.T, csvFile string) string {
+	t.Helper()
+	last, err := getLastID(csvFile, getTrackerFileName(csvFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return last.id
+}
+
+func TestRunWithWorkersIndexesEveryRow(t *testing.T) {
+	const rows = 2000
+	lines := make([]string, rows)
+	for i := range lines {
+		lines[i] = testRow(i + 1)
+	}
+	s := newBulkServer(t)
+	cfg := testConfig(s, writeTestCSV(t, lines...))
+	cfg.Workers = 4
+	// Many small requests keep several workers busy at once
+	cfg.FlushBytes = 4096
+
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]int)
+	for _, a := range s.received() {
+		seen[fmt.Sprint(a.meta["_id"])]++
+	}
+	for i := 1; i <= rows; i++ {
+		if n := seen[fmt.Sprint(i)]; n != 1 {
+			t.Errorf("document %d received %d times, want 1", i, n)
+		}
+	}
+	if len(seen) != rows {
+		t.Errorf("received %d documents, want %d", len(seen), rows)
+	}
+	if id := trackedID(t, cfg.CSVFiles[0]); id != fmt.Sprint(rows) {
+		t.Errorf("tracker last ID = %q, want %d", id, rows)
+	}
+}
+
+func TestRunFlushesEveryBulkSize(t *testing.T) {
+	lines := make([]string, 10)
+	for i := range lines {
+		lines[i] = testRow(i + 1)
+	}
+	s := newBulkServer(t)
+	var requests atomic.Int32
+	s.onBulk = func() { requests.Add(1) }
+	cfg := testConfig(s, writeTestCSV(t, lines...))
+	cfg.BulkSize = 3
+
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.received()); n != len(lines) {
+		t.Errorf("received %d documents, want %d", n, len(lines))
+	}
+	if n := requests.Load(); n != 4 {
+		t.Errorf("sent %d bulk requests, want 4", n)
+	}
+}
+
+func TestProgressTrackerAdvancesMonotonically(t *testing.T) {
+	const documents = 100
+	csvFile := writeTestCSV(t)
+	trackerFile := getTrackerFileName(csvFile)
+	tracker := newProgressTracker(fileTracker{csvFile: csvFile, path: trackerFile})
+
+	// Workers complete their documents in any order, the tracker only saves
+	// the last ID of the documents completed without a gap before them
+	completed := make(map[int]bool)
+	contiguous := 0
+	for _, seq := range rand.Perm(documents) {
+		tracker.done(seq, trackerEntry{id: fmt.Sprint(seq + 1), offset: int64(seq+1) * 100, line: seq + 2})
+		if err := tracker.save(); err != nil {
+			t.Fatal(err)
+		}
+		for completed[seq] = true; completed[contiguous]; contiguous++ {
+		}
+
+		last, err := getLastID(csvFile, trackerFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := ""
+		if contiguous > 0 {
+			want = fmt.Sprint(contiguous)
+		}
+		if last.id != want || last.offset != int64(contiguous)*100 {
+			t.Fatalf("after document %d the tracker is at %q offset %d, want %q offset %d", seq, last.id, last.offset, want, contiguous*100)
+		}
+	}
+	if contiguous != documents {
+		t.Errorf("%d documents completed, want %d", contiguous, documents)
+	}
+}
+
+func TestRunSendsPipeline(t *testing.T) {
+	for _, pipeline := range []string{"", "normalize-postcodes"} {
+		s := newBulkServer(t)
+		cfg := testConfig(s, writeTestCSV(t, testRow(1), testRow(2)))
+		cfg.Pipeline = pipeline
+		if err := Run(context.Background(), cfg); err != nil {
+			t.Fatal(err)
+		}
+
+		actions := s.received()
+		if len(actions) != 2 {
+			t.Fatalf("received %d actions, want 2", len(actions))
+		}
+		for _, a := range actions {
+			query, err := url.ParseQuery(a.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := query.Get("pipeline"); got != pipeline {
+				t.Errorf("pipeline = %q, want %q", got, pipeline)
+			}
+		}
+	}
+}
+
+func TestRunFailsBulkRequestsPastTimeout(t *testing.T) {
+	s := newBulkServer(t)
+	s.onBulk = func() { time.Sleep(500 * time.Millisecond) }
+	cfg := testConfig(s, writeTestCSV(t, testRow(1), testRow(2)))
+	cfg.RequestTimeout = 50 * time.Millisecond
+
+	// The bulk indexer reports the context error as text
+	err := Run(context.Background(), cfg)
+	if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Fatalf("Run = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestRunInterruptedSavesProgress(t *testing.T) {
+	const rows = 5000
+	lines := make([]string, rows)
+	for i := range lines {
+		lines[i] = testRow(i + 1)
+	}
+	s := newBulkServer(t)
+	cfg := testConfig(s, writeTestCSV(t, lines...))
+	cfg.FlushBytes = 4096
+
+	// Interrupt the import once the first request reaches the server
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.onBulk = cancel
+	if err := Run(ctx, cfg); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run = %v, want %v", err, context.Canceled)
+	}
+
+	interrupted := len(s.received())
+	if interrupted == 0 || interrupted == rows {
+		t.Fatalf("received %d documents before the interrupt, want some of %d", interrupted, rows)
+	}
+	if id := trackedID(t, cfg.CSVFiles[0]); id != fmt.Sprint(interrupted) {
+		t.Errorf("tracker last ID = %q, want %d", id, interrupted)
+	}
+
+	// Running again resumes after the flushed documents
+	s.onBulk = nil
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	actions := s.received()
+	if len(actions) != rows {
+		t.Fatalf("received %d documents in total, want %d", len(actions), rows)
+	}
+	for i, a := range actions {
+		if id := fmt.Sprint(a.meta["_id"]); id != fmt.Sprint(i+1) {
+			t.Fatalf("document %d has ID %s, want %d", i+1, id, i+1)
+		}
+	}
+}
+
+func TestRunSendsRouting(t *testing.T) {
+	s := newBulkServer(t)
+	chittagong := strings.Replace(testRow(2), "Dhaka,BD", "Chittagong,BD", 1)
+	cfg := testConfig(s, writeTestCSV(t, testRow(1), chittagong))
+	cfg.RoutingField = "city"
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"1": "Dhaka", "2": "Chittagong"}
+	actions := s.received()
+	if len(actions) != len(want) {
+		t.Fatalf("received %d actions, want %d", len(actions), len(want))
+	}
+	for _, a := range actions {
+		id := fmt.Sprint(a.meta["_id"])
+		if routing := a.meta["routing"]; routing != want[id] {
+			t.Errorf("document %s routing = %v, want %q", id, routing, want[id])
+		}
+	}
+}
+
+func TestRunMultilineAddress(t *testing.T) {
+	multiline := strings.Replace(testRow(2), "Road 2", "\"House 5, Road 2\nBlock C\"", 1)
+	for _, lazyQuotes := range []bool{false, true} {
+		s := newBulkServer(t)
+		cfg := testConfig(s, writeTestCSV(t, testRow(1), multiline, testRow(3)))
+		cfg.LazyQuotes = lazyQuotes
+		if err := Run(context.Background(), cfg); err != nil {
+			t.Fatalf("lazy quotes %t: %v", lazyQuotes, err)
+		}
+
+		actions := s.received()
+		if len(actions) != 3 {
+			t.Fatalf("lazy quotes %t: received %d documents, want 3", lazyQuotes, len(actions))
+		}
+		if address := actions[1].doc["address"]; address != "House 5, Road 2\nBlock C" {
+			t.Errorf("lazy quotes %t: address = %q, want the multiline address", lazyQuotes, address)
+		}
+		// The columns after the address are not shifted
+		for i, a := range actions {
+			if id := fmt.Sprint(a.meta["_id"]); id != fmt.Sprint(i+1) {
+				t.Errorf("lazy quotes %t: document %d has ID %s", lazyQuotes, i+1, id)
+			}
+			if city := a.doc["city"]; city != "Dhaka" {
+				t.Errorf("lazy quotes %t: document %d city = %v, want Dhaka", lazyQuotes, i+1, city)
+			}
+			if _, ok := a.doc["latlng"].(map[string]interface{}); !ok {
+				t.Errorf("lazy quotes %t: document %d latlng = %v, want a point", lazyQuotes, i+1, a.doc["latlng"])
+			}
+		}
+	}
+}
+
+func TestCheckOpenQuote(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  []string
+		columns int
+		open    bool
+	}{
+		{"single line", []string{"1", "Road 1", "Dhaka", "BD"}, 4, false},
+		{"multiline address", []string{"1", "House 5, Road 2\nBlock C", "Dhaka", "BD"}, 4, false},
+		{"swallowed rows", []string{"1", "Road 1,Dhaka,BD\n2,Road 2,Dhaka,BD\n3,Road 3", "Dhaka", "BD"}, 4, true},
+		{"swallowed row with other delimiter", []string{"1", "Road 1;Dhaka;BD;x\n", "Dhaka", "BD"}, 4, false},
+		{"two columns", []string{"1", "House 5, Road 2\nBlock C, Dhaka"}, 2, false},
+		{"three columns", []string{"1", "House 5, Road 2, Gulshan\nBlock C", "Dhaka"}, 3, false},
+	}
+	for _, tt := range tests {
+		err := checkOpenQuote(tt.record, tt.columns, ',')
+		if tt.open && err == nil {
+			t.Errorf("%s: checkOpenQuote succeeded, want an open quote error", tt.name)
+		}
+		if !tt.open && err != nil {
+			t.Errorf("%s: checkOpenQuote: %v", tt.name, err)
+		}
+	}
+}
+
+func TestRunSendsVersion(t *testing.T) {
+	for _, versionType := range []string{"external", "external_gte"} {
+		s := newBulkServer(t)
+		cfg := testConfig(s, writeTestCSV(t, testRow(1), strings.Replace(testRow(2), ",1207,", ",1300,", 1)))
+		cfg.VersionField = "postalCode"
+		cfg.VersionType = versionType
+		if err := Run(context.Background(), cfg); err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[string]float64{"1": 1207, "2": 1300}
+		actions := s.received()
+		if len(actions) != len(want) {
+			t.Fatalf("received %d actions, want %d", len(actions), len(want))
+		}
+		for _, a := range actions {
+			id := fmt.Sprint(a.meta["_id"])
+			if version := a.meta["version"]; version != want[id] {
+				t.Errorf("document %s version = %v, want %g", id, version, want[id])
+			}
+			if got := a.meta["version_type"]; got != versionType {
+				t.Errorf("document %s version_type = %v, want %s", id, got, versionType)
+			}
+		}
+	}
+}
+
+func TestSampleRowsTruncatedGzip(t *testing.T) {
+	// Fewer rows than sampled, so that the sample reaches the truncation
+	lines := make([]string, inspectRows/2)
+	for i := range lines {
+		lines[i] = testRow(i + 1)
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	fmt.Fprintf(gz, "%s\n%s\n", testHeader, strings.Join(lines, "\n"))
+	gz.Close()
+	path := filepath.Join(t.TempDir(), "locations.csv.gz")
+	if err := os.WriteFile(path, buf.Bytes()[:buf.Len()-20], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	imp := &importer{cfg: DefaultConfig()}
+	if _, _, err := imp.sampleRows(path); err == nil {
+		t.Error("sampleRows of a truncated gzip file succeeded, want an error")
+	}
+}
+
+func TestBuildMappingFieldTypes(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.FieldTypes = map[string]string{"postalCode": "int", "plusCode": "float", "district": "bool", "division": "string"}
+	cfg.BoolFields = append(cfg.BoolFields, "verified")
+	cfg.DateFields = []string{"updatedAt"}
+	cfg.FieldNames = map[string]string{"postalCode": "zip"}
+	mapping, err := buildMapping(defaultIndexMapping, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body struct {
+		Mappings struct {
+			Properties map[string]struct {
+				Type string `json:"type"`
+			} `json:"properties"`
+		} `json:"mappings"`
+	}
+	if err := json.Unmarshal(mapping, &body); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"zip":                   "long",
+		"plusCode":              "double",
+		"district":              "boolean",
+		"division":              "text",
+		"isAutocompleteAddress": "boolean",
+		"verified":              "boolean",
+		"updatedAt":             "date",
+		"country":               "keyword",
+	}
+	for field, typ := range want {
+		if got := body.Mappings.Properties[field].Type; got != typ {
+			t.Errorf("%s mapped as %q, want %q", field, got, typ)
+		}
+	}
+}
+
+func TestRunMirrorsFinalRejectedAttempt(t *testing.T) {
+	var attempts atomic.Int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		if !strings.HasSuffix(r.URL.Path, "/_bulk") {
+			fmt.Fprint(w, `{"version":{"number":"8.15.0"}}`)
+			return
+		}
+		attempts.Add(1)
+		http.Error(w, `{"error":"rejected"}`, http.StatusTooManyRequests)
+	}))
+	t.Cleanup(primary.Close)
+	mirror := newBulkServer(t)
+
+	cfg := testConfig(mirror, writeTestCSV(t, testRow(1), testRow(2)))
+	cfg.ESURL = primary.URL
+	cfg.MirrorURLs = []string{mirror.URL}
+	cfg.MaxRetries = 1
+	// Keep the backoff after the 429 short
+	cfg.ThrottleInitial = 10 * time.Millisecond
+	cfg.ThrottleMax = 10 * time.Millisecond
+	Run(context.Background(), cfg)
+
+	if n := attempts.Load(); n != 2 {
+		t.Fatalf("primary received %d attempts, want 2", n)
+	}
+	// Only the final attempt is copied
+	if n := len(mirror.received()); n != 2 {
+		t.Errorf("mirror received %d documents, want 2", n)
+	}
+}
@@ -0,0 +1,275 @@
+package eslocationseed
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// Default index mapping, declaring latlng as a geo_point
+const defaultIndexMapping = `{
+  "mappings": {
+    "properties": {
+      "placeId":               { "type": "keyword" },
+      "address":               { "type": "text", "fields": { "keyword": { "type": "keyword", "ignore_above": 256 } } },
+      "latlng":                { "type": "geo_point" },
+      "types":                 { "type": "keyword" },
+      "isAutocompleteAddress": { "type": "boolean" },
+      "country":               { "type": "keyword" },
+      "city":                  { "type": "text", "fields": { "keyword": { "type": "keyword", "ignore_above": 256 } } },
+      "division":              { "type": "text", "fields": { "keyword": { "type": "keyword", "ignore_above": 256 } } },
+      "district":              { "type": "text", "fields": { "keyword": { "type": "keyword", "ignore_above": 256 } } },
+      "postalCode":            { "type": "keyword" },
+      "plusCode":              { "type": "keyword" },
+      "ingestedAt":            { "type": "date" }
+    }
+  }
+}`
+
+// Elasticsearch types of the -field-types conversions, string keeps the
+// default mapping
+var fieldTypeMappings = map[string]string{
+	"int":   "long",
+	"float": "double",
+	"bool":  "boolean",
+}
+
+// Matches the {field} placeholders of an index name template
+var indexPlaceholder = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// Returns the index pattern matching every index of a template, the index
+// itself when it has no placeholders
+func indexPattern(index string) string {
+	return indexPlaceholder.ReplaceAllString(index, "*")
+}
+
+// Returns the fields named by the placeholders of an index name template
+func indexFields(index string) []string {
+	var fields []string
+	for _, match := range indexPlaceholder.FindAllStringSubmatch(index, -1) {
+		fields = append(fields, match[1])
+	}
+	return fields
+}
+
+// Checks an index name against the Elasticsearch naming rules
+func validIndexName(name string) error {
+	switch {
+	case name == "" || name == "." || name == "..":
+		return fmt.Errorf("invalid index name %q", name)
+	case len(name) > 255:
+		return fmt.Errorf("index name %q is longer than 255 bytes", name)
+	case strings.ContainsAny(name[:1], "-_+"):
+		return fmt.Errorf("index name %q must not start with -, _ or +", name)
+	case strings.ContainsAny(name, "\\/*?\"<>| ,#:"):
+		return fmt.Errorf("index name %q must not contain \\, /, *, ?, \", <, >, |, space, comma, # or :", name)
+	case strings.ToLower(name) != name:
+		return fmt.Errorf("index name %q must be lowercase", name)
+	}
+	return nil
+}
+
+// Reports whether the index exists
+func indexExists(es *elasticsearch.Client, index string) (bool, error) {
+	res, err := es.Indices.Exists([]string{index})
+	if err != nil {
+		return false, fmt.Errorf("error checking index: %w", err)
+	}
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("error checking index: %s", res.String())
+	}
+}
+
+// Creates the index with the configured mapping if it does not exist yet
+func ensureIndex(es *elasticsearch.Client, index string, cfg Config) error {
+	exists, err := indexExists(es, index)
+	if err != nil || exists {
+		return err
+	}
+
+	var mapping []byte
+	if cfg.IndexMapping != "" {
+		mapping, err = os.ReadFile(cfg.IndexMapping)
+		if err != nil {
+			return fmt.Errorf("error reading index mapping: %w", err)
+		}
+	} else {
+		mapping, err = buildMapping(defaultIndexMapping, cfg)
+		if err != nil {
+			return fmt.Errorf("error building index mapping: %w", err)
+		}
+	}
+
+	res, err := es.Indices.Create(index, es.Indices.Create.WithBody(bytes.NewReader(mapping)))
+	if err != nil {
+		return fmt.Errorf("error creating index: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error creating index: %s", res.String())
+	}
+	slog.Info("Created index", "index", index)
+	return nil
+}
+
+// Adapts the default mapping to the geo type and the converted fields, and
+// renames its properties to the configured document field names
+func buildMapping(mapping string, cfg Config) ([]byte, error) {
+	var body struct {
+		Mappings struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"mappings"`
+	}
+	if err := json.Unmarshal([]byte(mapping), &body); err != nil {
+		return nil, err
+	}
+	properties := body.Mappings.Properties
+	// Converted values would be rejected by, or indexed as, the string types
+	for field, typ := range cfg.FieldTypes {
+		if esType, ok := fieldTypeMappings[typ]; ok {
+			properties[field] = json.RawMessage(`{ "type": "` + esType + `" }`)
+		}
+	}
+	for _, field := range cfg.BoolFields {
+		properties[field] = json.RawMessage(`{ "type": "boolean" }`)
+	}
+	for _, field := range cfg.DateFields {
+		properties[field] = json.RawMessage(`{ "type": "date" }`)
+	}
+	if cfg.GeoType != "point" {
+		properties["latlng"] = json.RawMessage(`{ "type": "geo_shape" }`)
+	}
+	// The raw row is kept for reading only, not searched
+	if cfg.KeepRaw != "" {
+		properties[cfg.KeepRaw] = json.RawMessage(`{ "type": "text", "index": false }`)
+	}
+	if cfg.IDEncoding != "raw" {
+		properties[cfg.IDOriginalField] = json.RawMessage(`{ "type": "keyword" }`)
+	}
+	for field, name := range cfg.FieldNames {
+		if property, ok := properties[field]; ok {
+			delete(properties, field)
+			properties[name] = property
+		}
+	}
+	return json.Marshal(body)
+}
+
+// Refreshes the index, making all indexed documents visible to search
+func refreshIndex(es *elasticsearch.Client, index string) error {
+	res, err := es.Indices.Refresh(es.Indices.Refresh.WithIndex(index))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("%s", res.String())
+	}
+	slog.Info("Refreshed index", "index", index)
+	return nil
+}
+
+// Returns the number of documents in the index, 0 if it does not exist
+func countDocuments(es *elasticsearch.Client, index string) (int64, error) {
+	res, err := es.Count(es.Count.WithIndex(index))
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return 0, nil
+	}
+	if res.IsError() {
+		return 0, fmt.Errorf("%s", res.String())
+	}
+	var body struct {
+		Count int64 `json:"count"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return 0, fmt.Errorf("error parsing count response: %w", err)
+	}
+	return body.Count, nil
+}
+
+// Atomically moves the alias to the index, returning the indices it was
+// removed from
+func promoteAlias(es *elasticsearch.Client, alias, index string) ([]string, error) {
+	res, err := es.Indices.GetAlias(es.Indices.GetAlias.WithName(alias))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	// Indices currently behind the alias, none when it does not exist yet
+	current := map[string]json.RawMessage{}
+	if res.StatusCode != http.StatusNotFound {
+		if res.IsError() {
+			return nil, fmt.Errorf("%s", res.String())
+		}
+		if err := json.NewDecoder(res.Body).Decode(&current); err != nil {
+			return nil, fmt.Errorf("error parsing alias response: %w", err)
+		}
+	}
+
+	var previous []string
+	actions := []map[string]interface{}{
+		{"add": map[string]string{"index": index, "alias": alias}},
+	}
+	for name := range current {
+		if name != index {
+			previous = append(previous, name)
+			actions = append(actions, map[string]interface{}{"remove": map[string]string{"index": name, "alias": alias}})
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{"actions": actions})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err = es.Indices.UpdateAliases(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("%s", res.String())
+	}
+	slog.Info("Promoted alias", "alias", alias, "index", index, "previous", previous)
+	return previous, nil
+}
+
+// Deletes the indices
+func deleteIndices(es *elasticsearch.Client, indices []string) error {
+	if len(indices) == 0 {
+		return nil
+	}
+	res, err := es.Indices.Delete(indices)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("%s", res.String())
+	}
+	slog.Info("Deleted indices", "indices", indices)
+	return nil
+}
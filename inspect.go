@@ -0,0 +1,160 @@
+package eslocationseed
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+)
+
+// Rows read by -inspect to guess the column types
+const inspectRows = 20
+
+// Matches the start of a WKT geometry
+var wktRegex = regexp.MustCompile(`(?i)^\s*(POINT|LINESTRING|POLYGON|MULTIPOINT|MULTILINESTRING|MULTIPOLYGON|GEOMETRYCOLLECTION)\b`)
+
+// Prints the columns of every file with a sample value and a guessed type,
+// followed by the -columns mapping of the fields to the columns matching them
+func (imp *importer) inspect(w io.Writer) error {
+	for _, csvFile := range imp.cfg.CSVFiles {
+		header, rows, err := imp.sampleRows(csvFile)
+		if err != nil {
+			return fmt.Errorf("%s: %w", csvFile, err)
+		}
+		fmt.Fprintf(w, "%s: %d columns, %d rows sampled\n", csvFile, len(header), len(rows))
+
+		types := make([]string, len(header))
+		table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(table, "COLUMN\tTYPE\tSAMPLE")
+		for i, name := range header {
+			var values []string
+			for _, row := range rows {
+				if i < len(row) && strings.TrimSpace(row[i]) != "" {
+					values = append(values, strings.TrimSpace(row[i]))
+				}
+			}
+			types[i] = guessType(values)
+			sample := ""
+			if len(values) > 0 {
+				sample = values[0]
+			}
+			fmt.Fprintf(table, "%s\t%s\t%s\n", name, types[i], sample)
+		}
+		table.Flush()
+
+		overrides, unmapped := suggestColumns(header, types)
+		if len(overrides) > 0 {
+			fmt.Fprintf(w, "Suggested mapping: -columns %s\n", strings.Join(overrides, ","))
+		} else if len(unmapped) == 0 {
+			fmt.Fprintln(w, "The header matches the default mapping")
+		}
+		if len(unmapped) > 0 {
+			fmt.Fprintf(w, "No column found for: %s\n", strings.Join(unmapped, ", "))
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// Reads the header and up to inspectRows rows of a file
+func (imp *importer) sampleRows(csvFile string) ([]string, [][]string, error) {
+	file, err := imp.openCSV(csvFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening CSV file: %w", err)
+	}
+	defer file.Close()
+
+	reader := imp.newReader(file, nil)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading header: %w", err)
+	}
+	var rows [][]string
+	for len(rows) < inspectRows {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// Malformed rows say nothing reliable about the columns, other
+			// errors such as a truncated gzip stream would repeat forever
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				continue
+			}
+			return nil, nil, fmt.Errorf("error reading CSV file: %w", err)
+		}
+		rows = append(rows, record)
+	}
+	return header, rows, nil
+}
+
+// Returns the type shared by all values: bool, number, wkt-geometry or
+// string, empty when there are no values
+func guessType(values []string) string {
+	if len(values) == 0 {
+		return "empty"
+	}
+	all := func(match func(string) bool) bool {
+		return !slices.ContainsFunc(values, func(v string) bool { return !match(v) })
+	}
+	switch {
+	case all(func(v string) bool {
+		return slices.Contains([]string{"true", "false", "yes", "no"}, strings.ToLower(v))
+	}):
+		return "bool"
+	case all(func(v string) bool { _, err := strconv.ParseFloat(v, 64); return err == nil }):
+		return "number"
+	case all(wktRegex.MatchString):
+		return "wkt-geometry"
+	}
+	return "string"
+}
+
+// Returns the field=column overrides mapping the fields to the columns named
+// alike, ignoring case and separators, and the fields without such a column.
+// The latlng field falls back to the first WKT column.
+func suggestColumns(header, types []string) ([]string, []string) {
+	columns := make(map[string]string, len(header))
+	for _, name := range header {
+		columns[normalizeColumn(name)] = name
+	}
+	fields := make([]string, 0, len(defaultColumnNames))
+	for field := range defaultColumnNames {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+
+	var overrides, unmapped []string
+	for _, field := range fields {
+		column, ok := columns[normalizeColumn(defaultColumnNames[field])]
+		if !ok && field == "latlng" {
+			if i := slices.Index(types, "wkt-geometry"); i >= 0 {
+				column, ok = header[i], true
+			}
+		}
+		switch {
+		case !ok:
+			unmapped = append(unmapped, field)
+		case column != defaultColumnNames[field]:
+			overrides = append(overrides, field+"="+column)
+		}
+	}
+	return overrides, unmapped
+}
+
+// Lowercases a column name and drops everything but its letters and digits
+func normalizeColumn(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
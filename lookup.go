@@ -0,0 +1,92 @@
+package eslocationseed
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Table mapping the values of a field to the values indexed, in the field
+// itself or in a target field next to it
+type fieldLookup struct {
+	field  string
+	target string
+	values map[string]string
+}
+
+// Parses field[>target]=table specs, the table being inline value:mapped
+// pairs separated by commas, or @file for a two-column CSV file without header
+func parseLookups(specs []string) ([]fieldLookup, error) {
+	var lookups []fieldLookup
+	targets := make(map[string]string)
+	for _, spec := range specs {
+		fields, table, ok := strings.Cut(spec, "=")
+		field, target, _ := strings.Cut(fields, ">")
+		field, target = strings.TrimSpace(field), strings.TrimSpace(target)
+		if !ok || field == "" {
+			return nil, fmt.Errorf("expected field[>target]=table, got %q", spec)
+		}
+		if _, known := defaultColumnNames[field]; !known || field == "id" || field == "latlng" {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		if target == "" {
+			target = field
+		}
+		if other, taken := targets[target]; taken {
+			return nil, fmt.Errorf("%s and %s are both looked up into %s", other, field, target)
+		}
+		targets[target] = field
+
+		var values map[string]string
+		var err error
+		if path, isFile := strings.CutPrefix(strings.TrimSpace(table), "@"); isFile {
+			values, err = readLookupFile(path)
+		} else {
+			values, err = parseLookupTable(table)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field, err)
+		}
+		lookups = append(lookups, fieldLookup{field: field, target: target, values: values})
+	}
+	return lookups, nil
+}
+
+// Parses inline value:mapped pairs separated by commas
+func parseLookupTable(table string) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, pair := range splitList(table) {
+		value, mapped, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("expected value:mapped, got %q", pair)
+		}
+		values[strings.TrimSpace(value)] = strings.TrimSpace(mapped)
+	}
+	return values, nil
+}
+
+// Reads the value and mapped value columns of a lookup CSV file
+func readLookupFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening lookup file: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 2
+	values := make(map[string]string)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return values, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading lookup file %s: %w", path, err)
+		}
+		values[strings.TrimSpace(record[0])] = strings.TrimSpace(record[1])
+	}
+}
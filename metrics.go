@@ -0,0 +1,86 @@
+package eslocationseed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Upper bounds in seconds of the bulk request latency histogram buckets
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Serves the import counters in the Prometheus text format on /metrics,
+// returning a function shutting the server down
+func (imp *importer) serveMetrics(addr string) (stop func(), err error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error listening for metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		imp.writeMetrics(w)
+	})
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server failed", "error", err)
+		}
+	}()
+	slog.Info("Serving metrics", "addr", listener.Addr().String())
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Warn("Error shutting down metrics server", "error", err)
+		}
+	}, nil
+}
+
+// Writes the counters and the bulk request latency histogram
+func (imp *importer) writeMetrics(w io.Writer) {
+	c := imp.counts()
+	counter := func(name, help string, value int64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+	}
+	counter("eslocationseed_documents_imported_total", "Documents indexed.", c.imported)
+	counter("eslocationseed_documents_unchanged_total", "Documents an upsert left unchanged.", c.noops)
+	counter("eslocationseed_documents_deleted_total", "Documents deleted.", c.deleted)
+	counter("eslocationseed_rows_skipped_total", "Rows skipped as malformed.", c.skipped)
+	counter("eslocationseed_rows_filtered_total", "Rows left out by the filters.", c.filtered)
+	counter("eslocationseed_documents_failed_total", "Documents rejected by Elasticsearch.", c.failed)
+
+	imp.latencyMu.Lock()
+	latencies := imp.latencies
+	buckets := make([]int, len(latencyBuckets))
+	var sum float64
+	for _, latency := range latencies {
+		seconds := latency.Seconds()
+		sum += seconds
+		for i, bound := range latencyBuckets {
+			if seconds <= bound {
+				buckets[i]++
+			}
+		}
+	}
+	count := len(latencies)
+	imp.latencyMu.Unlock()
+
+	counter("eslocationseed_bulk_requests_total", "Bulk requests sent.", int64(count))
+	name := "eslocationseed_bulk_request_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Duration of the bulk requests.\n# TYPE %s histogram\n", name, name)
+	for i, bound := range latencyBuckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, bound, buckets[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %g\n%s_count %d\n", name, count, name, sum, name, count)
+
+	// Alerts on stalls compare this with the current time
+	name = "eslocationseed_last_bulk_request_timestamp_seconds"
+	fmt.Fprintf(w, "# HELP %s Time the last bulk request completed.\n# TYPE %s gauge\n%s %d\n", name, name, name, imp.lastBatch.Load())
+}
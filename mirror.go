@@ -0,0 +1,197 @@
+package eslocationseed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// Cluster receiving a copy of every bulk request sent to the primary cluster
+type mirror struct {
+	url string
+	es  *elasticsearch.Client
+
+	// Items indexed and rejected by the mirror, and those whose outcome
+	// differs from the primary
+	succeeded atomic.Int64
+	failed    atomic.Int64
+	diverged  atomic.Int64
+}
+
+// Connects to every -mirror-urls cluster with the same credentials as the
+// primary cluster, unless given in the URL
+func newMirrors(ctx context.Context, cfg Config) ([]*mirror, error) {
+	var mirrors []*mirror
+	for _, mirrorURL := range cfg.MirrorURLs {
+		mirrorCfg := cfg
+		mirrorCfg.ESURL = mirrorURL
+		mirrorCfg.CloudID = ""
+		// The copied requests are compressed already when the primary's are
+		mirrorCfg.CompressRequests = false
+		es, err := newClient(ctx, mirrorCfg, newThrottle(cfg), nil)
+		if err != nil {
+			return nil, fmt.Errorf("mirror %s: %w", redactURL(mirrorURL), err)
+		}
+		mirrors = append(mirrors, &mirror{url: redactURL(mirrorURL), es: es})
+	}
+	return mirrors, nil
+}
+
+// Hides the password of a URL for logging
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Redacted()
+}
+
+// Transport copying every bulk request to the mirrors once the primary
+// cluster answered it for good, so the mirrors receive the same batches in the
+// same order. Only the response of the primary is returned, failures of the
+// mirrors are counted without affecting the import.
+type mirrorTransport struct {
+	http.RoundTripper
+	mirrors []*mirror
+	retries *retryCounter
+}
+
+// Counts the attempts of the bulk requests the client retries, which passes
+// the same request to the transport on every attempt
+type retryCounter struct {
+	mu         sync.Mutex
+	maxRetries int
+	attempts   map[*http.Request]int
+}
+
+func newRetryCounter(maxRetries int) *retryCounter {
+	return &retryCounter{maxRetries: maxRetries, attempts: make(map[*http.Request]int)}
+}
+
+// Reports whether the client retries an attempt, forgetting the request once
+// it does not
+func (r *retryCounter) willRetry(req *http.Request, failed bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if failed && r.attempts[req] < r.maxRetries {
+		r.attempts[req]++
+		return true
+	}
+	delete(r.attempts, req)
+	return false
+}
+
+func (t mirrorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !strings.HasSuffix(req.URL.Path, "/_bulk") || req.Body == nil {
+		return t.RoundTripper.RoundTrip(req)
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	res, err := t.RoundTripper.RoundTrip(req)
+	// Attempts that are retried are not copied, the mirrors get the last one,
+	// even when the primary rejected it too
+	failed := err != nil || slices.Contains(retryStatuses, res.StatusCode)
+	if t.retries.willRetry(req, failed) || err != nil {
+		return res, err
+	}
+	data, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(data))
+	primary := bulkOutcomes(res.StatusCode, data)
+
+	var wg sync.WaitGroup
+	for _, m := range t.mirrors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.send(req, body, primary)
+		}()
+	}
+	wg.Wait()
+	return res, nil
+}
+
+// Sends a copy of a bulk request and compares the outcome of its items with
+// the outcome on the primary cluster
+func (m *mirror) send(original *http.Request, body []byte, primary []bool) {
+	req := original.Clone(original.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	// The mirror client sets its own credentials
+	req.Header.Del("Authorization")
+
+	var outcomes []bool
+	res, err := m.es.Perform(req)
+	if err == nil {
+		var data []byte
+		data, err = io.ReadAll(res.Body)
+		res.Body.Close()
+		outcomes = bulkOutcomes(res.StatusCode, data)
+		if err == nil && res.StatusCode >= http.StatusBadRequest {
+			err = fmt.Errorf("%s: %s", res.Status, data)
+		}
+	}
+	if err != nil {
+		slog.Error("Mirror bulk request failed", "mirror", m.url, "error", err)
+	}
+
+	diverged := 0
+	for i := range max(len(primary), len(outcomes)) {
+		ok := i < len(outcomes) && outcomes[i]
+		if ok {
+			m.succeeded.Add(1)
+		} else {
+			m.failed.Add(1)
+		}
+		if ok != (i < len(primary) && primary[i]) {
+			diverged++
+		}
+	}
+	if diverged > 0 {
+		m.diverged.Add(int64(diverged))
+		slog.Warn("Mirror diverged from the primary cluster", "mirror", m.url, "items", diverged)
+	}
+}
+
+// Returns whether each item of a bulk response succeeded, the items of a
+// failed request being unknown
+func bulkOutcomes(status int, data []byte) []bool {
+	if status >= http.StatusBadRequest {
+		return nil
+	}
+	var response struct {
+		Items []map[string]struct {
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil
+	}
+	outcomes := make([]bool, len(response.Items))
+	for i, item := range response.Items {
+		for _, result := range item {
+			outcomes[i] = result.Status <= http.StatusCreated && len(result.Error) == 0
+		}
+	}
+	return outcomes
+}
@@ -0,0 +1,176 @@
+package eslocationseed
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Reads the rows of an input file, implemented by csv.Reader and ndjsonReader
+type recordReader interface {
+	Read() ([]string, error)
+	FieldPos(field int) (line, column int)
+	InputOffset() int64
+}
+
+// Reads newline-delimited JSON as rows of the JSON paths of the first object.
+// The first Read returns these paths as the header, like the first row of a
+// CSV file, nested fields being joined with dots.
+type ndjsonReader struct {
+	reader     *bufio.Reader
+	header     []string
+	first      []string
+	line       int
+	offset     int64
+	coordOrder string
+	separator  string
+}
+
+// Creates a reader for NDJSON, given the header already read when resuming
+// past the start of the file
+func newNDJSONReader(r io.Reader, header []string, cfg Config) *ndjsonReader {
+	return &ndjsonReader{
+		reader:     bufio.NewReader(r),
+		header:     header,
+		coordOrder: cfg.CoordOrder,
+		separator:  cfg.TypesSeparator,
+	}
+}
+
+// Returns the next row, with the values of the header paths
+func (r *ndjsonReader) Read() ([]string, error) {
+	if r.first != nil {
+		record := r.first
+		r.first = nil
+		return record, nil
+	}
+
+	for {
+		data, err := r.reader.ReadBytes('\n')
+		if len(data) == 0 && err != nil {
+			return nil, err
+		}
+		r.offset += int64(len(data))
+		r.line++
+		data = bytes.TrimSpace(data)
+		if len(data) == 0 {
+			continue
+		}
+
+		values, err := r.flatten(data)
+		if err != nil {
+			// Reported like a malformed CSV row so -skip-bad-rows applies
+			return nil, &csv.ParseError{StartLine: r.line, Line: r.line, Column: 1, Err: err}
+		}
+		if r.header == nil {
+			for path := range values {
+				r.header = append(r.header, path)
+			}
+			sort.Strings(r.header)
+			r.first = r.record(values)
+			return r.header, nil
+		}
+		return r.record(values), nil
+	}
+}
+
+// Returns the line of the last row read, every field being on the same line
+func (r *ndjsonReader) FieldPos(field int) (int, int) {
+	return r.line, 1
+}
+
+// Returns the input offset after the last row read
+func (r *ndjsonReader) InputOffset() int64 {
+	return r.offset
+}
+
+// Picks the values of the header paths, missing paths being empty
+func (r *ndjsonReader) record(values map[string]string) []string {
+	record := make([]string, len(r.header))
+	for i, path := range r.header {
+		record[i] = values[path]
+	}
+	return record
+}
+
+// Decodes a JSON object into the string values of its paths
+func (r *ndjsonReader) flatten(data []byte) (map[string]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if object == nil {
+		return nil, fmt.Errorf("expected a JSON object, got %s", data)
+	}
+	values := make(map[string]string)
+	r.flattenValue("", object, values)
+	return values, nil
+}
+
+// Adds the value under its path, descending into objects
+func (r *ndjsonReader) flattenValue(path string, value interface{}, values map[string]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		// Points given as objects are also available as WKT for latlng
+		if point, ok := r.point(v); ok && path != "" {
+			values[path] = point
+		}
+		for key, child := range v {
+			if path != "" {
+				key = path + "." + key
+			}
+			r.flattenValue(key, child, values)
+		}
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			switch item.(type) {
+			case map[string]interface{}, []interface{}:
+				data, _ := json.Marshal(v)
+				values[path] = string(data)
+				return
+			}
+			parts = append(parts, scalarString(item))
+		}
+		values[path] = strings.Join(parts, r.separator)
+	default:
+		values[path] = scalarString(v)
+	}
+}
+
+// Formats a {"lat", "lon"} object or a GeoJSON point as a WKT point, in the
+// -coord-order expected of the latlng values
+func (r *ndjsonReader) point(object map[string]interface{}) (string, bool) {
+	lat, latOK := object["lat"].(json.Number)
+	lon, lonOK := object["lon"].(json.Number)
+	if coordinates, ok := object["coordinates"].([]interface{}); ok && object["type"] == "Point" && len(coordinates) == 2 {
+		lon, lonOK = coordinates[0].(json.Number)
+		lat, latOK = coordinates[1].(json.Number)
+	}
+	if !latOK || !lonOK {
+		return "", false
+	}
+	if r.coordOrder == "latlon" {
+		return fmt.Sprintf("POINT (%s %s)", lat, lon), true
+	}
+	return fmt.Sprintf("POINT (%s %s)", lon, lat), true
+}
+
+// Formats a JSON scalar as it would appear in a CSV column, null being empty
+func scalarString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,122 @@
+package eslocationseed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Run report written to the -report file for downstream steps
+type runReport struct {
+	Status    string         `json:"status"`
+	Error     string         `json:"error,omitempty"`
+	Started   time.Time      `json:"started"`
+	Finished  time.Time      `json:"finished"`
+	ElapsedMs int64          `json:"elapsedMs"`
+	Counts    reportCounts   `json:"counts"`
+	LastID    string         `json:"lastId"`
+	Files     []fileReport   `json:"files"`
+	FailedIDs []string       `json:"failedIds"`
+	Mirrors   []mirrorReport `json:"mirrors,omitempty"`
+}
+
+// Outcome of the copied bulk requests on a mirror in the run report
+type mirrorReport struct {
+	URL       string `json:"url"`
+	Succeeded int64  `json:"succeeded"`
+	Failed    int64  `json:"failed"`
+	Diverged  int64  `json:"diverged"`
+}
+
+// Outcome of importing a single file in the run report
+type fileReport struct {
+	File       string       `json:"file"`
+	Counts     reportCounts `json:"counts"`
+	LastID     string       `json:"lastId"`
+	ErrorLines []int        `json:"errorLines"`
+}
+
+// Import counters in the run report
+type reportCounts struct {
+	Imported   int64 `json:"imported"`
+	Skipped    int64 `json:"skipped"`
+	Failed     int64 `json:"failed"`
+	Deleted    int64 `json:"deleted"`
+	NotFound   int64 `json:"notFound"`
+	Filtered   int64 `json:"filtered"`
+	Conflicts  int64 `json:"conflicts"`
+	Stale      int64 `json:"stale"`
+	Duplicates int64 `json:"duplicates"`
+	Unchanged  int64 `json:"unchanged"`
+}
+
+// Converts the import counters for the run report
+func newReportCounts(c counts) reportCounts {
+	return reportCounts{
+		Imported:   c.imported,
+		Skipped:    c.skipped,
+		Failed:     c.failed,
+		Deleted:    c.deleted,
+		NotFound:   c.notFound,
+		Filtered:   c.filtered,
+		Conflicts:  c.conflicts,
+		Stale:      c.stale,
+		Duplicates: c.duplicates,
+		Unchanged:  c.noops,
+	}
+}
+
+// Writes the run report as JSON, the status tells a completed run from an
+// interrupted or failed one
+func (imp *importer) writeReport(path string, results []fileResult, started time.Time, runErr error) error {
+	report := runReport{
+		Status:    "completed",
+		Started:   started,
+		Finished:  time.Now(),
+		ElapsedMs: time.Since(started).Milliseconds(),
+		Counts:    newReportCounts(imp.counts()),
+		Files:     []fileReport{},
+		FailedIDs: imp.failedIDs,
+	}
+	if report.FailedIDs == nil {
+		report.FailedIDs = []string{}
+	}
+	if runErr != nil {
+		report.Status = "failed"
+		report.Error = runErr.Error()
+		if errors.Is(runErr, context.Canceled) {
+			report.Status = "interrupted"
+		}
+	}
+	for _, m := range imp.mirrors {
+		report.Mirrors = append(report.Mirrors, mirrorReport{
+			URL:       m.url,
+			Succeeded: m.succeeded.Load(),
+			Failed:    m.failed.Load(),
+			Diverged:  m.diverged.Load(),
+		})
+	}
+	for _, r := range results {
+		report.Files = append(report.Files, fileReport{
+			File:       r.file,
+			Counts:     newReportCounts(r.counts),
+			LastID:     r.lastID,
+			ErrorLines: r.errorLines,
+		})
+		if r.lastID != "" {
+			report.LastID = r.lastID
+		}
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing report: %w", err)
+	}
+	return nil
+}
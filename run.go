@@ -0,0 +1,433 @@
+package eslocationseed
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// Runs the import of all configured CSV files, checking the configuration
+// first. It returns the context error when interrupted, after the pending
+// documents were flushed and the progress was saved.
+func Run(ctx context.Context, cfg Config) (err error) {
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	imp := &importer{cfg: cfg, builder: cfg.Builder, ensured: make(map[string]bool), throttle: newThrottle(cfg)}
+	if imp.builder == nil {
+		imp.builder = NewDocumentBuilder(cfg)
+	}
+	started := time.Now()
+
+	// Describe the run for downstream steps, however it ended
+	var results []fileResult
+	if cfg.Report != "" {
+		defer func() {
+			if reportErr := imp.writeReport(cfg.Report, results, started, err); reportErr != nil && err == nil {
+				err = reportErr
+			}
+		}()
+	}
+
+	if cfg.CountOnly {
+		return imp.countRows()
+	}
+	if cfg.ResetTracker {
+		if cfg.TrackerBackend == "es" {
+			if imp.es, err = newClient(ctx, cfg, imp.throttle, nil); err != nil {
+				return err
+			}
+		}
+		return imp.resetTrackers()
+	}
+	if cfg.Check {
+		return imp.check(ctx)
+	}
+	if cfg.Inspect {
+		return imp.inspect(os.Stdout)
+	}
+	if cfg.ExplainBatch > 0 {
+		return imp.explainBatch(os.Stdout, cfg.ExplainBatch)
+	}
+
+	if cfg.MetricsAddr != "" {
+		stop, err := imp.serveMetrics(cfg.MetricsAddr)
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+
+	// Connect to Elasticsearch unless only validating
+	if !cfg.DryRun {
+		var err error
+		imp.mirrors, err = newMirrors(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		imp.es, err = newClient(ctx, cfg, imp.throttle, imp.mirrors)
+		if err != nil {
+			return err
+		}
+		if cfg.CreateIndex && !indexPlaceholder.MatchString(cfg.Index) {
+			if err := imp.ensureIndex(cfg.Index); err != nil {
+				return fmt.Errorf("error preparing index: %w", err)
+			}
+			imp.ensured[cfg.Index] = true
+		}
+	}
+
+	// Count the existing documents to verify the import against
+	var countBefore int64
+	if cfg.Verify && !cfg.DryRun {
+		var err error
+		countBefore, err = countDocuments(imp.es, indexPattern(cfg.Index))
+		if err != nil {
+			return fmt.Errorf("error counting documents: %w", err)
+		}
+	}
+
+	// Import the files in order, each with its own tracker
+	for _, csvFile := range cfg.CSVFiles {
+		slog.Info("Importing file", "file", csvFile)
+		before := imp.counts()
+		errorLines, interrupted, err := imp.importFile(ctx, csvFile)
+		results = append(results, fileResult{
+			file:       csvFile,
+			counts:     imp.counts().sub(before),
+			lastID:     imp.lastID,
+			errorLines: errorLines,
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", csvFile, err)
+		}
+		if interrupted {
+			printSummary(results, imp.counts(), cfg.Delete)
+			return ctx.Err()
+		}
+		if imp.limitReached {
+			break
+		}
+	}
+
+	if cfg.DryRun {
+		printDryRunReport(results, imp.counts())
+		imp.printLimit()
+		return imp.printDuplicates()
+	}
+
+	// Make the imported documents searchable in one go
+	if cfg.RefreshAfter || cfg.Verify {
+		if err := refreshIndex(imp.es, indexPattern(cfg.Index)); err != nil {
+			return fmt.Errorf("error refreshing index: %w", err)
+		}
+		for _, m := range imp.mirrors {
+			if err := refreshIndex(m.es, indexPattern(cfg.Index)); err != nil {
+				slog.Error("Error refreshing mirror index", "mirror", m.url, "error", err)
+			}
+		}
+	}
+
+	// Notify completion
+	total := imp.counts()
+	slog.Info("Import complete",
+		"files", len(results),
+		"imported", total.imported,
+		"deleted", total.deleted,
+		"not_found", total.notFound,
+		"skipped", total.skipped,
+		"failed", total.failed,
+		"duration_ms", time.Since(started).Milliseconds())
+	fmt.Println("Upload complete.")
+	printSummary(results, imp.counts(), cfg.Delete)
+	imp.printThroughput(time.Since(started))
+	imp.printCollapsed()
+	imp.printRetries()
+	imp.printMirrors()
+	imp.printLimit()
+	if err := imp.printDuplicates(); err != nil {
+		return err
+	}
+	if cfg.Verify {
+		if err := imp.verify(countBefore); err != nil {
+			return err
+		}
+	}
+	if cfg.PromoteAlias != "" {
+		if err := imp.promote(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Points the alias at the loaded index once every document made it in
+func (imp *importer) promote() error {
+	if total := imp.counts(); total.failed > 0 {
+		return fmt.Errorf("not promoting alias %s: %d documents failed", imp.cfg.PromoteAlias, total.failed)
+	}
+	previous, err := promoteAlias(imp.es, imp.cfg.PromoteAlias, imp.cfg.Index)
+	if err != nil {
+		return fmt.Errorf("error promoting alias: %w", err)
+	}
+	fmt.Printf("Alias %s now points to %s.\n", imp.cfg.PromoteAlias, imp.cfg.Index)
+	if imp.cfg.DeleteOldIndex {
+		if err := deleteIndices(imp.es, previous); err != nil {
+			return fmt.Errorf("error deleting old indices: %w", err)
+		}
+	}
+
+	// Keep the mirrors pointing at the same index, a mirror failing does not
+	// undo the primary
+	for _, m := range imp.mirrors {
+		previous, err := promoteAlias(m.es, imp.cfg.PromoteAlias, imp.cfg.Index)
+		if err != nil {
+			slog.Error("Error promoting mirror alias", "mirror", m.url, "error", err)
+			continue
+		}
+		if imp.cfg.DeleteOldIndex {
+			if err := deleteIndices(m.es, previous); err != nil {
+				slog.Error("Error deleting old mirror indices", "mirror", m.url, "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+// Creates the index if missing on the primary cluster and the mirrors
+func (imp *importer) ensureIndex(index string) error {
+	if err := ensureIndex(imp.es, index, imp.cfg); err != nil {
+		return err
+	}
+	for _, m := range imp.mirrors {
+		if err := ensureIndex(m.es, index, imp.cfg); err != nil {
+			return fmt.Errorf("mirror %s: %w", m.url, err)
+		}
+	}
+	return nil
+}
+
+// Checks the setup of the import without importing anything, reporting
+// every problem found
+func (imp *importer) check(ctx context.Context) error {
+	failed := 0
+	report := func(err error, ok string) {
+		if err != nil {
+			fmt.Printf("FAIL %s\n", err)
+			failed++
+		} else {
+			fmt.Printf("OK   %s\n", ok)
+		}
+	}
+
+	var err error
+	if len(imp.cfg.MirrorURLs) > 0 {
+		imp.mirrors, err = newMirrors(ctx, imp.cfg)
+		report(err, fmt.Sprintf("%d mirrors reachable", len(imp.mirrors)))
+	}
+	if err == nil {
+		imp.es, err = newClient(ctx, imp.cfg, imp.throttle, nil)
+		report(err, "Elasticsearch reachable with the configured credentials")
+	}
+	if err == nil {
+		report(imp.checkIndex(imp.es), fmt.Sprintf("index %s is ready", imp.cfg.Index))
+		for _, m := range imp.mirrors {
+			if err := imp.checkIndex(m.es); err != nil {
+				report(fmt.Errorf("mirror %s: %w", m.url, err), "")
+			}
+		}
+	}
+
+	for _, csvFile := range imp.cfg.CSVFiles {
+		report(imp.checkFile(csvFile), fmt.Sprintf("%s opens with the expected header", csvFile))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
+
+// Checks the index exists, or will be created by the import
+func (imp *importer) checkIndex(es *elasticsearch.Client) error {
+	// Indices named after the rows are only known once the rows are read
+	if indexPlaceholder.MatchString(imp.cfg.Index) {
+		return nil
+	}
+	exists, err := indexExists(es, imp.cfg.Index)
+	if err != nil {
+		return err
+	}
+	if !exists && !imp.cfg.CreateIndex {
+		return fmt.Errorf("index %s does not exist, create it or use -create-index", imp.cfg.Index)
+	}
+	return nil
+}
+
+// Checks the file opens and its header has the columns of the configured fields
+func (imp *importer) checkFile(csvFile string) error {
+	if csvFile == stdinFile {
+		return nil
+	}
+	file, err := imp.openCSV(csvFile)
+	if err != nil {
+		return fmt.Errorf("%s: error opening CSV file: %w", csvFile, err)
+	}
+	defer file.Close()
+
+	header, err := imp.newReader(file, nil).Read()
+	if err != nil {
+		return fmt.Errorf("%s: error reading header: %w", csvFile, err)
+	}
+	if err := imp.mapHeader(header); err != nil {
+		return fmt.Errorf("%s: %w", csvFile, err)
+	}
+	return nil
+}
+
+// Deletes the trackers of every file so the next import starts from the top
+func (imp *importer) resetTrackers() error {
+	for _, csvFile := range imp.cfg.CSVFiles {
+		if csvFile == stdinFile {
+			continue
+		}
+		store := imp.trackerStore(csvFile)
+		removed, err := store.remove()
+		if err != nil {
+			return fmt.Errorf("error removing tracker of %s: %w", csvFile, err)
+		}
+		if removed {
+			fmt.Printf("Removed tracker %s.\n", store)
+		} else {
+			fmt.Printf("No tracker for %s.\n", csvFile)
+		}
+	}
+	return nil
+}
+
+// Prints the number of rows of every file, excluding the header
+func (imp *importer) countRows() error {
+	total := 0
+	for _, csvFile := range imp.cfg.CSVFiles {
+		lines, err := imp.countLines(csvFile)
+		if err != nil {
+			return fmt.Errorf("%s: error counting rows: %w", csvFile, err)
+		}
+		rows := imp.rowCount(lines)
+		total += rows
+		if len(imp.cfg.CSVFiles) > 1 {
+			fmt.Printf("%s: %d rows\n", csvFile, rows)
+		}
+	}
+	fmt.Printf("Total: %d rows\n", total)
+	return nil
+}
+
+// Compares the document count of the index with the count expected from the
+// created and deleted documents
+func (imp *importer) verify(countBefore int64) error {
+	count, err := countDocuments(imp.es, indexPattern(imp.cfg.Index))
+	if err != nil {
+		return fmt.Errorf("error counting documents: %w", err)
+	}
+	created, updated, deleted := imp.created.Load(), imp.updated.Load(), imp.deleted.Load()
+	expected := countBefore + created - deleted
+	fmt.Printf("Created: %d, updated: %d, deleted: %d\n", created, updated, deleted)
+	if count != expected {
+		slog.Warn("Document count does not match the import, another writer may be using the index",
+			"count", count, "expected", expected, "count_before", countBefore, "created", created, "deleted", deleted)
+		fmt.Printf("Verify failed: index %s has %d documents, expected %d.\n", indexPattern(imp.cfg.Index), count, expected)
+		return nil
+	}
+	fmt.Printf("Verified: index %s has %d documents.\n", indexPattern(imp.cfg.Index), count)
+	return nil
+}
+
+// Prints the import rate and the bulk request latencies
+func (imp *importer) printThroughput(elapsed time.Duration) {
+	seconds := elapsed.Seconds()
+	fmt.Printf("Elapsed: %s, %.0f records/sec, %.2f MB/sec\n",
+		elapsed.Round(time.Millisecond), float64(imp.sent)/seconds, float64(imp.sentBytes)/(1<<20)/seconds)
+
+	imp.latencyMu.Lock()
+	defer imp.latencyMu.Unlock()
+	if len(imp.latencies) == 0 {
+		return
+	}
+	latencies := slices.Clone(imp.latencies)
+	slices.Sort(latencies)
+	var sum time.Duration
+	for _, l := range latencies {
+		sum += l
+	}
+	p95 := latencies[(len(latencies)*95+99)/100-1]
+	fmt.Printf("Bulk requests: %d, average latency: %s, p95 latency: %s\n",
+		len(latencies), (sum / time.Duration(len(latencies))).Round(time.Millisecond), p95.Round(time.Millisecond))
+}
+
+// Reports the duplicate rows collapsed within a batch
+func (imp *importer) printCollapsed() {
+	if n := imp.collapsed.Load(); n > 0 {
+		fmt.Printf("Collapsed %d rows replaced by a later row with the same _id in the same batch.\n", n)
+	}
+}
+
+// Lists the first duplicate _ids, failing the run on duplicates with -fail-on-duplicate-ids
+func (imp *importer) printDuplicates() error {
+	n := imp.duplicates.Load()
+	if n == 0 {
+		return nil
+	}
+	if imp.cfg.DetectDuplicateIDs == "bloom" {
+		fmt.Printf("Found %d rows possibly repeating an _id seen earlier in the same file, some may be false positives:\n", n)
+	} else {
+		fmt.Printf("Found %d rows repeating an _id seen earlier in the same file:\n", n)
+	}
+	for _, dup := range imp.duplicateIDs {
+		if dup.firstLine > 0 {
+			fmt.Printf("  %s on line %d, first seen on line %d\n", dup.id, dup.line, dup.firstLine)
+		} else {
+			fmt.Printf("  %s on line %d\n", dup.id, dup.line)
+		}
+	}
+	if n > int64(len(imp.duplicateIDs)) {
+		fmt.Printf("  and %d more\n", n-int64(len(imp.duplicateIDs)))
+	}
+	if imp.cfg.FailOnDuplicateIDs {
+		return fmt.Errorf("found %d duplicate IDs", n)
+	}
+	return nil
+}
+
+// Reports how many rejected documents were indexed on a retry
+func (imp *importer) printRetries() {
+	if n := imp.retried.Load(); n > 0 {
+		fmt.Printf("Retried %d rejected documents, %d recovered.\n", n, imp.recovered.Load())
+	}
+}
+
+// Reports the items every mirror indexed, and those that diverged from the primary
+func (imp *importer) printMirrors() {
+	for _, m := range imp.mirrors {
+		fmt.Printf("Mirror %s: succeeded: %d, failed: %d, diverged: %d\n", m.url, m.succeeded.Load(), m.failed.Load(), m.diverged.Load())
+	}
+}
+
+// Notes that the import stopped early because of the limit
+func (imp *importer) printLimit() {
+	if imp.limitReached {
+		done := imp.imported.Load()
+		if imp.cfg.Delete {
+			done = imp.deleted.Load()
+		}
+		fmt.Printf("Limit of %d rows reached with %d rows indexed, the remaining rows were not read.\n", imp.cfg.Limit, done)
+		if done < int64(imp.cfg.Limit) {
+			fmt.Println("Rows rejected after the last rows were read are not replaced.")
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package eslocationseed
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Adaptive delay between bulk requests, raised when Elasticsearch pushes back
+// with 429 responses and lowered again as batches succeed. While throttled
+// the workers flush one at a time.
+type throttle struct {
+	initial, min, max time.Duration
+
+	mu    sync.Mutex
+	delay time.Duration
+
+	// Held by the flushing worker while throttled
+	serial sync.Mutex
+}
+
+// Creates a throttle that starts unthrottled
+func newThrottle(cfg Config) *throttle {
+	return &throttle{initial: cfg.ThrottleInitial, min: cfg.ThrottleMin, max: cfg.ThrottleMax, delay: cfg.ThrottleMin}
+}
+
+// Returns the current delay
+func (t *throttle) current() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.delay
+}
+
+// Doubles the delay after a 429, starting from the initial delay
+func (t *throttle) pressure() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	previous := t.delay
+	t.delay = min(max(t.delay*2, t.initial), t.max)
+	if t.delay != previous {
+		slog.Warn("Elasticsearch is rejecting requests, slowing down", "delay", t.delay)
+	}
+}
+
+// Halves the delay after a successful batch, down to the minimum
+func (t *throttle) relief() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.delay <= t.min {
+		return
+	}
+	t.delay /= 2
+	if t.delay < t.initial {
+		t.delay = t.min
+	}
+	if t.delay == t.min {
+		slog.Info("Elasticsearch recovered, resuming full speed")
+	}
+}
+
+// Waits the current delay before a flush, returning whether the flush holds
+// the serial lock and must release it once done
+func (t *throttle) wait(ctx context.Context) bool {
+	delay := t.current()
+	if delay <= 0 {
+		return false
+	}
+	serial := delay > t.min
+	if serial {
+		t.serial.Lock()
+	}
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+	}
+	return serial
+}
+
+// Transport raising the throttle on every bulk request rejected with a 429
+type throttleTransport struct {
+	http.RoundTripper
+	throttle *throttle
+}
+
+func (t throttleTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.RoundTripper.RoundTrip(req)
+	if err == nil && res.StatusCode == http.StatusTooManyRequests && strings.HasSuffix(req.URL.Path, "/_bulk") {
+		t.throttle.pressure()
+	}
+	return res, err
+}
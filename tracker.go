@@ -0,0 +1,286 @@
+package eslocationseed
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Suffixes of the tracker file names derived from the CSV file name
+const (
+	trackerSuffix       = "_tracker.json"
+	legacyTrackerSuffix = "_tracker.csv"
+)
+
+// Resume state persisted in the tracker file
+type trackerState struct {
+	File    string    `json:"file"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	LastID  string    `json:"lastId"`
+	Offset  int64     `json:"offset"`
+	Line    int       `json:"line"`
+}
+
+// Position of a document in the CSV file, the offset and line point at the
+// end of its record
+type trackerEntry struct {
+	id     string
+	offset int64
+	line   int
+}
+
+// Storage of the resume state of a single CSV file
+type trackerStore interface {
+	// Returns the saved entry, empty when nothing was saved yet
+	load() (trackerEntry, error)
+	save(entry trackerEntry) error
+	// Deletes the saved entry, reporting whether there was one
+	remove() (bool, error)
+	String() string
+}
+
+// Tracker file next to the CSV file, or at -tracker-file
+type fileTracker struct {
+	csvFile string
+	path    string
+}
+
+func (f fileTracker) load() (trackerEntry, error) {
+	return getLastID(f.csvFile, f.path)
+}
+
+func (f fileTracker) save(entry trackerEntry) error {
+	return saveLastID(f.csvFile, f.path, entry)
+}
+
+func (f fileTracker) remove() (bool, error) {
+	return removeTracker(f.path)
+}
+
+func (f fileTracker) String() string {
+	return f.path
+}
+
+// Advances the last processed ID in document order, regardless of the
+// order in which concurrent workers complete their bulk requests
+type progressTracker struct {
+	mu      sync.Mutex
+	store   trackerStore
+	next    int
+	pending map[int]trackerEntry
+	last    trackerEntry
+	saved   trackerEntry
+	err     error
+}
+
+func newProgressTracker(store trackerStore) *progressTracker {
+	return &progressTracker{store: store, pending: make(map[int]trackerEntry)}
+}
+
+// Records a completed document and advances the highest contiguous last ID
+func (t *progressTracker) done(seq int, entry trackerEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.pending[seq] = entry
+	for {
+		entry, ok := t.pending[t.next]
+		if !ok {
+			break
+		}
+		delete(t.pending, t.next)
+		t.last = entry
+		t.next++
+	}
+}
+
+// Persists the last entry if it advanced since the previous save, rows
+// repeating the saved ID still move its offset
+func (t *progressTracker) save() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.store == nil || t.last.id == "" || t.last == t.saved {
+		return nil
+	}
+	if err := t.store.save(t.last); err != nil {
+		return fmt.Errorf("error saving last processed ID: %w", err)
+	}
+	t.saved = t.last
+	return nil
+}
+
+// Returns the highest contiguous completed ID, or the given ID when no
+// document completed yet
+func (t *progressTracker) lastID(resumed string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.last.id == "" {
+		return resumed
+	}
+	return t.last.id
+}
+
+// Saves the progress every interval until stopped, in addition to the saves
+// after every flush
+func (t *progressTracker) checkpoint(interval time.Duration) (stop func()) {
+	if t.store == nil || interval <= 0 {
+		return func() {}
+	}
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if err := t.save(); err != nil {
+					t.fail(err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// Records the first error raised while flushing in the background
+func (t *progressTracker) fail(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.err == nil {
+		t.err = err
+	}
+}
+
+// Returns the first background flush error, if any
+func (t *progressTracker) failure() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.err
+}
+
+// Returns the tracker path next to the CSV file, named after the file without
+// its extension
+func getTrackerFileName(csvFileName string) string {
+	csvFileName = strings.TrimSuffix(csvFileName, ".gz")
+	if ext := filepath.Ext(csvFileName); ext != "" {
+		return strings.TrimSuffix(csvFileName, ext) + "_last_id" + trackerSuffix
+	}
+	return csvFileName + trackerSuffix
+}
+
+// Returns the last processed ID and its position in the CSV file, refusing
+// to resume if the file changed since the tracker was written
+func getLastID(csvFile, trackerFile string) (trackerEntry, error) {
+	data, err := os.ReadFile(trackerFile)
+	if os.IsNotExist(err) {
+		return getLegacyLastID(csvFile, trackerFile)
+	}
+	if err != nil {
+		return trackerEntry{}, err
+	}
+
+	var state trackerState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return trackerEntry{}, fmt.Errorf("error parsing tracker file %s: %w", trackerFile, err)
+	}
+
+	info, err := os.Stat(csvFile)
+	if err != nil {
+		return trackerEntry{}, err
+	}
+	path, _ := filepath.Abs(csvFile)
+	if state.File != path || state.Size != info.Size() || !state.ModTime.Equal(info.ModTime()) {
+		return trackerEntry{}, fmt.Errorf("%s changed since tracker %s was written, delete the tracker to start fresh", csvFile, trackerFile)
+	}
+	return trackerEntry{id: state.LastID, offset: state.Offset, line: state.Line}, nil
+}
+
+// Reads the bare last ID written by older versions, which carries no
+// file fingerprint or offset
+func getLegacyLastID(csvFile, trackerFile string) (trackerEntry, error) {
+	legacyFile := strings.TrimSuffix(trackerFile, trackerSuffix) + legacyTrackerSuffix
+	data, err := os.ReadFile(legacyFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return trackerEntry{}, nil
+		}
+		return trackerEntry{}, err
+	}
+	slog.Warn("Resuming from a legacy tracker without a file check", "tracker", legacyFile, "file", csvFile)
+	return trackerEntry{id: strings.TrimSpace(string(data))}, nil
+}
+
+// Saves the resume state for the CSV file
+func saveLastID(csvFile, trackerFile string, entry trackerEntry) error {
+	info, err := os.Stat(csvFile)
+	if err != nil {
+		return fmt.Errorf("error reading CSV file info: %w", err)
+	}
+	path, _ := filepath.Abs(csvFile)
+	data, err := json.Marshal(trackerState{
+		File:    path,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		LastID:  entry.id,
+		Offset:  entry.offset,
+		Line:    entry.line,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(trackerFile), 0755); err != nil {
+		return fmt.Errorf("error creating tracker directory: %w", err)
+	}
+	// Write a temporary file and rename it over the tracker, so a crash never
+	// leaves a truncated tracker behind
+	file, err := os.CreateTemp(filepath.Dir(trackerFile), filepath.Base(trackerFile)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("error creating tracker file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing to tracker file: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing to tracker file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error writing to tracker file: %w", err)
+	}
+	if err := os.Rename(file.Name(), trackerFile); err != nil {
+		return fmt.Errorf("error replacing tracker file: %w", err)
+	}
+	return nil
+}
+
+// Deletes the tracker and its legacy counterpart, reporting whether any existed
+func removeTracker(trackerFile string) (bool, error) {
+	removed := false
+	legacyFile := strings.TrimSuffix(trackerFile, trackerSuffix) + legacyTrackerSuffix
+	for _, path := range []string{trackerFile, legacyFile} {
+		err := os.Remove(path)
+		if err == nil {
+			removed = true
+		} else if !os.IsNotExist(err) {
+			return removed, err
+		}
+	}
+	return removed, nil
+}